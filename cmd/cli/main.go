@@ -4,14 +4,20 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path"
 	"sort"
 	"strings"
+	"syscall"
 	"text/tabwriter"
+	"time"
 
 	"filippo.io/age"
 	"github.com/klauspost/pgzip"
+	"github.com/korbiniankuhn/auto-restic/internal/client"
 	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/history"
+	"github.com/korbiniankuhn/auto-restic/internal/progress"
 	"github.com/korbiniankuhn/auto-restic/internal/restic"
 	"github.com/korbiniankuhn/auto-restic/internal/s3"
 	"github.com/korbiniankuhn/auto-restic/internal/utils"
@@ -26,8 +32,23 @@ const (
 
 type Session struct {
 	Config config.Config
-	Restic restic.Restic
+	Repos  restic.Repositories
 	S3     *s3.S3
+	Client *client.Client
+}
+
+func (s *Session) requireLocal() error {
+	if s.Client != nil {
+		return fmt.Errorf("command is not supported with --server")
+	}
+	return nil
+}
+
+func (s *Session) requireRemote() error {
+	if s.Client == nil {
+		return fmt.Errorf("command requires --server")
+	}
+	return nil
 }
 
 func panicOnError(message string, err error) {
@@ -45,33 +66,140 @@ func initConfigAndLogging() config.Config {
 	return c
 }
 
-func initRestic(c config.Config) restic.Restic {
-	// Initialize restic
-	r, err := restic.NewRestic(c.Restic.Repository, c.Restic.Password)
-	panicOnError("failed to initialize restic", err)
-	return r
+// initRestic initializes the given repository or all repositories if empty
+func initRestic(ctx context.Context, c config.Config, name string) restic.Repositories {
+	repos := restic.Repositories{}
+	for _, repo := range c.Repositories {
+		if name != "" && repo.Name != name {
+			continue
+		}
+		r, err := restic.NewRestic(ctx, repo.Name, restic.Options{
+			Repository:      repo.Repository,
+			Password:        repo.Password,
+			PasswordFile:    repo.PasswordFile,
+			PasswordCommand: repo.PasswordCommand,
+			Env:             repo.Env(),
+		})
+		panicOnError("failed to initialize restic repository "+repo.Name, err)
+		repos = append(repos, r)
+	}
+	if len(repos) == 0 {
+		panicOnError("failed to initialize restic", fmt.Errorf("unknown repository: %s", name))
+	}
+	return repos
 }
 
-func initS3(c config.Config) *s3.S3 {
-	s, err := s3.Get(c.S3.AccessKey, c.S3.SecretKey, c.S3.Endpoint, c.S3.Bucket)
+func initS3(ctx context.Context, c config.Config) *s3.S3 {
+	s, err := s3.Get(ctx, c.S3.AccessKey, c.S3.SecretKey, c.S3.Endpoint, c.S3.Bucket)
 	panicOnError("failed to initialize s3", err)
 	return s
 }
 
+// followProgress shows a progress bar until the triggered job is no longer running
+func followProgress(ctx context.Context, c *client.Client, job, name string) error {
+	jobName := job + ":" + name
+	startedAt := time.Now()
+	seen := false
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		status, err := c.GetStatus()
+		if err != nil {
+			return fmt.Errorf("failed to get status: %w", err)
+		}
+
+		running := false
+		for _, j := range status.Running {
+			if j.Name == jobName {
+				running = true
+			}
+		}
+
+		if running {
+			seen = true
+			list, err := c.GetProgress()
+			if err != nil {
+				return fmt.Errorf("failed to get progress: %w", err)
+			}
+			for _, p := range list {
+				if p.Job == job && p.Backup == name {
+					fmt.Printf("\r%s", formatProgress(p))
+				}
+			}
+		} else if seen || (status.Queued == 0 && time.Since(startedAt) > 5*time.Second) {
+			// The job may take a moment to show up as running after it was triggered
+			fmt.Println()
+			fmt.Println("Job finished, see the server logs or history for the result")
+			return nil
+		} else {
+			fmt.Printf("\rWaiting for %d queued job(s)", status.Queued)
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func formatProgress(p progress.Progress) string {
+	const width = 30
+	filled := min(int(p.PercentDone*width), width)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+
+	line := fmt.Sprintf("[%s] %5.1f%% %s", bar, p.PercentDone*100, formatBytes(p.BytesDone))
+	if p.TotalFiles > 0 {
+		line += fmt.Sprintf(", %d/%d files", p.FilesDone, p.TotalFiles)
+	}
+	if p.SecondsRemaining > 0 {
+		line += fmt.Sprintf(", %s remaining", time.Duration(p.SecondsRemaining)*time.Second)
+	}
+	// Overwrite leftovers of a longer previous line
+	return line + "    "
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
 func main() {
 	rootCmd := &cobra.Command{
 		Use:   "auto-restic",
 		Short: "AutoRestic backup tool",
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			server, _ := cmd.Flags().GetString("server")
+			token, _ := cmd.Flags().GetString("token")
+
+			// Remote mode talks to the server API and needs no local config
+			if server != "" {
+				session := &Session{Client: client.New(server, token)}
+				ctx := context.WithValue(cmd.Context(), ctxKeySession, session)
+				cmd.SetContext(ctx)
+				return nil
+			}
+
 			c := initConfigAndLogging()
 			session := &Session{Config: c}
 
 			if cmd.Parent() != nil && cmd.Parent().Name() == "restic" {
-				session.Restic = initRestic(c)
+				repo, _ := cmd.Flags().GetString("repo")
+				session.Repos = initRestic(cmd.Context(), c, repo)
 			}
 
 			if cmd.Parent() != nil && cmd.Parent().Name() == "s3" {
-				session.S3 = initS3(c)
+				session.S3 = initS3(cmd.Context(), c)
 			}
 
 			ctx := context.WithValue(cmd.Context(), ctxKeySession, session)
@@ -80,10 +208,14 @@ func main() {
 		},
 	}
 
+	rootCmd.PersistentFlags().String("server", os.Getenv("AUTO_RESTIC_SERVER"), "URL of a running server to use instead of local restic and S3 (e.g. http://localhost:2112)")
+	rootCmd.PersistentFlags().String("token", os.Getenv("AUTO_RESTIC_TOKEN"), "API token of the server")
+
 	resticCmd := &cobra.Command{
 		Use:   "restic",
 		Short: "Manage restic backups",
 	}
+	resticCmd.PersistentFlags().String("repo", "", "Name of the repository to use (default: all for ls and restore, the one of the backup for rm)")
 
 	resticCmd.AddCommand(&cobra.Command{
 		Use:   "ls",
@@ -91,12 +223,22 @@ func main() {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			session := cmd.Context().Value(ctxKeySession).(*Session)
 
-			snapshots, err := session.Restic.ListSnapshots()
+			var snapshots []restic.Snapshot
+			var err error
+			if session.Client != nil {
+				repo, _ := cmd.Flags().GetString("repo")
+				snapshots, err = session.Client.ListSnapshots(repo)
+			} else {
+				snapshots, err = session.Repos.ListSnapshots(cmd.Context())
+			}
 			if err != nil {
 				return fmt.Errorf("failed to list restic snapshots: %w", err)
 			}
 
 			sort.Slice(snapshots, func(i, j int) bool {
+				if snapshots[i].Repository != snapshots[j].Repository {
+					return snapshots[i].Repository < snapshots[j].Repository
+				}
 				if snapshots[i].Name == snapshots[j].Name {
 					return snapshots[i].Time.After(snapshots[j].Time)
 				}
@@ -104,10 +246,10 @@ func main() {
 			})
 
 			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-			fmt.Fprintln(w, "Name\tDate\tID")
-			fmt.Fprintln(w, "----\t----\t--")
+			fmt.Fprintln(w, "Repository\tName\tDate\tID")
+			fmt.Fprintln(w, "----------\t----\t----\t--")
 			for _, s := range snapshots {
-				fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, s.Time.Format("2006-01-02 15:04:05"), s.ID)
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Repository, s.Name, s.Time.Format("2006-01-02 15:04:05"), s.ID)
 			}
 			w.Flush()
 			return nil
@@ -122,8 +264,22 @@ func main() {
 			backupName, _ := cmd.Flags().GetString("name")
 
 			session := cmd.Context().Value(ctxKeySession).(*Session)
+			if err := session.requireLocal(); err != nil {
+				return err
+			}
 
-			output, err := session.Restic.RemoveBackupDirectory(backupName)
+			// Without --repo the backup is removed from its configured repository
+			r := session.Repos[0]
+			for _, backup := range session.Config.Backups {
+				if backup.Name != backupName {
+					continue
+				}
+				if repo, err := session.Repos.Get(backup.Repository); err == nil {
+					r = repo
+				}
+			}
+
+			output, err := r.RemoveBackupDirectory(cmd.Context(), backupName)
 			if err != nil {
 				return fmt.Errorf("failed to remove restic snapshots: %w", err)
 			}
@@ -143,8 +299,16 @@ func main() {
 			snapshotID, _ := cmd.Flags().GetString("snapshot-id")
 			mountPath, _ := cmd.Flags().GetString("mount-path")
 			session := cmd.Context().Value(ctxKeySession).(*Session)
+			if err := session.requireLocal(); err != nil {
+				return err
+			}
 
-			err := session.Restic.Restore(snapshotID, mountPath)
+			// Without --repo the snapshot is looked up in all repositories
+			r, err := session.Repos.FindSnapshot(cmd.Context(), snapshotID)
+			if err != nil {
+				return fmt.Errorf("failed to find restic snapshot: %w", err)
+			}
+			err = r.Restore(cmd.Context(), snapshotID, mountPath)
 			if err != nil {
 				return fmt.Errorf("failed to restore restic snapshot: %w", err)
 			}
@@ -170,7 +334,13 @@ func main() {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			session := cmd.Context().Value(ctxKeySession).(*Session)
 
-			objects, err := session.S3.ListObjects()
+			var objects []s3.S3Object
+			var err error
+			if session.Client != nil {
+				objects, err = session.Client.ListS3Objects()
+			} else {
+				objects, err = session.S3.ListObjects(cmd.Context())
+			}
 			if err != nil {
 				return fmt.Errorf("failed to list S3 objects: %w", err)
 			}
@@ -200,8 +370,11 @@ func main() {
 			objectKey, _ := cmd.Flags().GetString("object-key")
 			versionID, _ := cmd.Flags().GetString("version-id")
 			session := cmd.Context().Value(ctxKeySession).(*Session)
+			if err := session.requireLocal(); err != nil {
+				return err
+			}
 
-			err := session.S3.RemoveObject(objectKey, versionID)
+			err := session.S3.RemoveObject(cmd.Context(), objectKey, versionID)
 			if err != nil {
 				return fmt.Errorf("failed to remove S3 object: %w", err)
 			}
@@ -224,6 +397,9 @@ func main() {
 			versionID, _ := cmd.Flags().GetString("version-id")
 			mountPath, _ := cmd.Flags().GetString("mount-path")
 			session := cmd.Context().Value(ctxKeySession).(*Session)
+			if err := session.requireLocal(); err != nil {
+				return err
+			}
 
 			decryptedPath := path.Join(mountPath, strings.TrimSuffix(objectKey, ".tar.gz.age"))
 
@@ -234,7 +410,7 @@ func main() {
 			}
 
 			// Get a reader for the S3 object (streaming)
-			s3Reader, err := session.S3.StreamDownloadFile(objectKey, versionID)
+			s3Reader, err := session.S3.StreamDownloadFile(cmd.Context(), objectKey, versionID)
 			if err != nil {
 				return fmt.Errorf("failed to get S3 stream: %w", err)
 			}
@@ -268,9 +444,155 @@ func main() {
 	s3RestoreCmd.MarkFlagRequired("mount-path")
 	s3Cmd.AddCommand(s3RestoreCmd)
 
-	rootCmd.AddCommand(resticCmd, s3Cmd)
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List past job runs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			job, _ := cmd.Flags().GetString("job")
+			name, _ := cmd.Flags().GetString("name")
+			status, _ := cmd.Flags().GetString("status")
+			since, _ := cmd.Flags().GetDuration("since")
+			limit, _ := cmd.Flags().GetInt("limit")
+			session := cmd.Context().Value(ctxKeySession).(*Session)
+			if err := session.requireLocal(); err != nil {
+				return err
+			}
+
+			h, err := history.Open(session.Config.DataDir)
+			if err != nil {
+				return fmt.Errorf("failed to open history: %w", err)
+			}
+
+			filter := history.Filter{
+				Job:    job,
+				Backup: name,
+				Status: history.Status(status),
+				Limit:  limit,
+			}
+			if since > 0 {
+				filter.Since = time.Now().Add(-since)
+			}
+
+			runs, err := h.List(filter)
+			if err != nil {
+				return fmt.Errorf("failed to list history: %w", err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "Job\tName\tStarted\tDuration\tStatus\tSnapshot\tAdded\tUploaded\tError")
+			fmt.Fprintln(w, "---\t----\t-------\t--------\t------\t--------\t-----\t--------\t-----")
+			for _, r := range runs {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n", r.Job, r.Backup, r.StartedAt.Format("2006-01-02 15:04:05"), time.Duration(r.Duration*float64(time.Second)).Round(time.Second), r.Status, r.SnapshotID, r.BytesAdded, r.BytesUploaded, r.Error)
+			}
+			w.Flush()
+			return nil
+		},
+	}
+	historyCmd.Flags().String("job", "", "Filter by job (backup, s3, check, prune)")
+	historyCmd.Flags().String("name", "", "Filter by backup name")
+	historyCmd.Flags().String("status", "", "Filter by status (success, failure)")
+	historyCmd.Flags().Duration("since", 0, "Only show runs started within this duration (e.g. 24h)")
+	historyCmd.Flags().Int("limit", 50, "Maximum number of runs to show (0 for all)")
+
+	runCmd := &cobra.Command{
+		Use:   "run",
+		Short: "Trigger a job on the server",
+	}
+	for _, job := range []string{"backup", "s3"} {
+		jobCmd := &cobra.Command{
+			Use:   job,
+			Short: fmt.Sprintf("Trigger the %s job of a backup", job),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				name, _ := cmd.Flags().GetString("name")
+				session := cmd.Context().Value(ctxKeySession).(*Session)
+				if err := session.requireRemote(); err != nil {
+					return err
+				}
+
+				if err := session.Client.RunJob(job, name); err != nil {
+					return fmt.Errorf("failed to trigger %s job: %w", job, err)
+				}
+
+				println("Triggered", job, "job for backup:", name)
+
+				detach, _ := cmd.Flags().GetBool("detach")
+				if detach {
+					return nil
+				}
+				return followProgress(cmd.Context(), session.Client, job, name)
+			},
+		}
+		jobCmd.Flags().String("name", "", "Name of the backup")
+		jobCmd.Flags().Bool("detach", false, "Return after triggering instead of showing the progress")
+		jobCmd.MarkFlagRequired("name")
+		runCmd.AddCommand(jobCmd)
+	}
+	for _, job := range []string{"check", "prune"} {
+		runCmd.AddCommand(&cobra.Command{
+			Use:   job,
+			Short: fmt.Sprintf("Trigger the %s job", job),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				session := cmd.Context().Value(ctxKeySession).(*Session)
+				if err := session.requireRemote(); err != nil {
+					return err
+				}
+
+				if err := session.Client.RunJob(job, ""); err != nil {
+					return fmt.Errorf("failed to trigger %s job: %w", job, err)
+				}
+
+				println("Triggered", job, "job")
+				return nil
+			},
+		})
+	}
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show running, queued and scheduled jobs of the server",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			session := cmd.Context().Value(ctxKeySession).(*Session)
+			if err := session.requireRemote(); err != nil {
+				return err
+			}
+
+			status, err := session.Client.GetStatus()
+			if err != nil {
+				return fmt.Errorf("failed to get status: %w", err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "Running\tStarted")
+			fmt.Fprintln(w, "-------\t-------")
+			for _, j := range status.Running {
+				fmt.Fprintf(w, "%s\t%s\n", j.Name, j.StartedAt.Format("2006-01-02 15:04:05"))
+			}
+			w.Flush()
+			fmt.Println("\nQueued jobs:", status.Queued)
+			fmt.Println()
+
+			w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "Job\tLast Run\tNext Run")
+			fmt.Fprintln(w, "---\t--------\t--------")
+			for _, j := range status.Scheduled {
+				lastRun := "-"
+				if !j.LastRun.IsZero() {
+					lastRun = j.LastRun.Format("2006-01-02 15:04:05")
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", j.Name, lastRun, j.NextRun.Format("2006-01-02 15:04:05"))
+			}
+			w.Flush()
+			return nil
+		},
+	}
+
+	rootCmd.AddCommand(resticCmd, s3Cmd, historyCmd, runCmd, statusCmd)
+
+	// Cancel running restic and s3 calls on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err := rootCmd.Execute(); err != nil {
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println("command execution failed:", err)
 		os.Exit(1)
 	}
@@ -6,12 +6,17 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/korbiniankuhn/auto-restic/internal/api"
 	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/history"
 	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+	"github.com/korbiniankuhn/auto-restic/internal/notify"
+	"github.com/korbiniankuhn/auto-restic/internal/progress"
 	"github.com/korbiniankuhn/auto-restic/internal/restic"
 	"github.com/korbiniankuhn/auto-restic/internal/s3"
 	"github.com/korbiniankuhn/auto-restic/internal/task"
@@ -19,6 +24,9 @@ import (
 	"github.com/go-co-op/gocron/v2"
 )
 
+// Time for cancelled jobs to stop, e.g. restic removing its lock
+const jobCancelTimeout = time.Minute
+
 func panicOnError(message string, err error) {
 	if err != nil {
 		slog.Error(message, "error", err)
@@ -36,13 +44,23 @@ func main() {
 	c, err := config.Get()
 	panicOnError("failed to load config", err)
 
-	// Initialize restic
-	r, err := restic.NewRestic(c.Restic.Repository, c.Restic.Password)
-	panicOnError("failed to initialize restic", err)
-	slog.Info("restic initialized")
+	// Initialize restic repositories
+	repos := restic.Repositories{}
+	for _, repo := range c.Repositories {
+		r, err := restic.NewRestic(context.Background(), repo.Name, restic.Options{
+			Repository:      repo.Repository,
+			Password:        repo.Password,
+			PasswordFile:    repo.PasswordFile,
+			PasswordCommand: repo.PasswordCommand,
+			Env:             repo.Env(),
+		})
+		panicOnError("failed to initialize restic repository "+repo.Name, err)
+		repos = append(repos, r)
+	}
+	slog.Info("restic initialized", "repositories", len(repos))
 
 	// Initialize S3
-	s, err := s3.Get(c.S3.AccessKey, c.S3.SecretKey, c.S3.Endpoint, c.S3.Bucket)
+	s, err := s3.Get(context.Background(), c.S3.AccessKey, c.S3.SecretKey, c.S3.Endpoint, c.S3.Bucket)
 	panicOnError("failed to initialize s3", err)
 	slog.Info("s3 initialized")
 
@@ -59,62 +77,193 @@ func main() {
 		slog.Info("metrics enabled", "url", "/metrics")
 	}
 
+	// Initialise notifications
+	n, err := notify.New(c.Notifications)
+	panicOnError("failed to initialize notifications", err)
+	slog.Info("notifications initialized", "count", len(c.Notifications))
+
+	// Initialise run history and restore metrics of previous runs
+	h, err := history.Open(c.DataDir)
+	panicOnError("failed to initialize history", err)
+	backupRepositories := map[string]string{}
+	for _, backup := range c.Backups {
+		backupRepositories[backup.Name] = backup.Repository
+	}
+	if err := h.SeedMetrics(m, backupRepositories); err != nil {
+		slog.Error("failed to seed metrics from history", "error", err)
+	}
+	slog.Info("history initialized", "dir", c.DataDir)
+
+	// Live progress of running backups and uploads
+	p := progress.NewTracker(m)
+
 	// Wait group for graceful shutdown
 	wg := sync.WaitGroup{}
 
+	// Jobs run with their own context, so they are not cancelled before the shutdown timeout
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
 	// Schedule jobs
+	tracker := api.NewJobTracker()
+	// Concurrent jobs share the repositories, restic.Restic serializes exclusive operations like prune
 	scheduler, err := gocron.NewScheduler(
-		gocron.WithLimitConcurrentJobs(1, gocron.LimitModeWait),
+		gocron.WithLimitConcurrentJobs(uint(c.Concurrency.Jobs), gocron.LimitModeWait),
+		gocron.WithStopTimeout(c.ShutdownTimeout+jobCancelTimeout),
+		gocron.WithGlobalJobOptions(
+			gocron.WithSingletonMode(gocron.LimitModeWait),
+			gocron.WithEventListeners(
+				gocron.BeforeJobRuns(tracker.BeforeJobRuns),
+				gocron.AfterJobRuns(tracker.AfterJobRuns),
+			),
+		),
 	)
 	if err != nil {
 		panicOnError("failed to create scheduler", err)
 	}
 
-	// Pre backup scripts and restic snapshot creation
-	scheduler.NewJob(
-		gocron.CronJob(c.Cron.Backup, true),
-		gocron.NewTask(func() {
-			task.Backup(c, m, r)
-		}),
-	)
+	// One backup and S3 job per backup, each on its own schedule
+	for _, backup := range c.Backups {
+		backups := []config.BackupConfig{backup}
+
+		// Pre backup scripts and restic snapshot creation
+		_, err = scheduler.NewJob(
+			gocron.CronJob(backup.Cron, true),
+			gocron.NewTask(func() {
+				task.Backup(jobCtx, c, m, n, h, p, repos, backups)
+			}),
+			gocron.WithName("backup:"+backup.Name),
+		)
+		panicOnError("failed to schedule backup job", err)
+
+		// S3 backup
+		_, err = scheduler.NewJob(
+			gocron.CronJob(backup.S3Cron, true),
+			gocron.NewTask(func() {
+				task.S3Backup(jobCtx, c, m, n, h, p, repos, s, backups)
+			}),
+			gocron.WithName("s3:"+backup.Name),
+		)
+		panicOnError("failed to schedule s3 job", err)
+	}
+
+	// Backups discovered from container labels run on the global schedules,
+	// containers are discovered again at each run
+	if c.Docker.Discovery {
+		_, err = scheduler.NewJob(
+			gocron.CronJob(c.Cron.Backup, true),
+			gocron.NewTask(func() {
+				dc, backups := task.DiscoverBackups(jobCtx, c, m)
+				task.Backup(jobCtx, dc, m, n, h, p, repos, backups)
+			}),
+			gocron.WithName("backup:discovered"),
+		)
+		panicOnError("failed to schedule discovered backup job", err)
+
+		_, err = scheduler.NewJob(
+			gocron.CronJob(c.Cron.S3, true),
+			gocron.NewTask(func() {
+				dc, backups := task.DiscoverBackups(jobCtx, c, m)
+				task.S3Backup(jobCtx, dc, m, n, h, p, repos, s, backups)
+			}),
+			gocron.WithName("s3:discovered"),
+		)
+		panicOnError("failed to schedule discovered s3 job", err)
+		slog.Info("docker discovery enabled", "socket", c.Docker.Socket)
+	}
 
 	// restic check
 	scheduler.NewJob(
 		gocron.CronJob(c.Cron.Check, true),
 		gocron.NewTask(func() {
-			task.ResticCheck(m, r)
+			task.ResticCheck(jobCtx, c, m, n, h, repos)
 		}),
+		gocron.WithName("check"),
 	)
 
 	// restic forget and prune
 	scheduler.NewJob(
 		gocron.CronJob(c.Cron.Prune, true),
 		gocron.NewTask(func() {
-			task.ForgetAndPrune(c, m, r)
-		}),
-	)
-
-	// S3 backup
-	scheduler.NewJob(
-		gocron.CronJob(c.Cron.S3, true),
-		gocron.NewTask(func() {
-			task.S3Backup(c, m, r, s)
+			task.ForgetAndPrune(jobCtx, c, m, n, h, repos)
 		}),
+		gocron.WithName("prune"),
 	)
 
 	// Capture restic and s3 stats at startup and regular intervals
 	scheduler.NewJob(
 		gocron.CronJob(c.Cron.Metrics, true),
 		gocron.NewTask(func() {
-			task.UpdateAllMetrics(c, m, r, s)
+			task.UpdateAllMetrics(jobCtx, c, m, repos, s)
 		}),
+		gocron.WithName("metrics"),
 		gocron.JobOption(gocron.WithStartImmediately()),
 	)
 
+	// REST API to inspect and trigger jobs
+	if c.API.Token != "" {
+		http.Handle("/api/", api.New(c, repos, s, scheduler, tracker, p).Handler())
+		slog.Info("api enabled", "url", "/api")
+	}
+
 	// Start scheduler
 	scheduler.Start()
 	slog.Info("scheduler started")
 
+	// Run jobs that missed their schedule while the server was down
+	if c.CatchUp.Enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			missed, err := task.MissedJobs(jobCtx, c, repos, s, h, time.Now())
+			if err != nil {
+				slog.Error("failed to check for missed jobs", "error", err)
+				return
+			}
+			// An S3 job uploads the latest snapshot, so it waits for the backup it catches up with
+			backupsFinished := map[string]<-chan struct{}{}
+			for _, missedJob := range missed {
+				for _, job := range scheduler.Jobs() {
+					if job.Name() != missedJob.Name {
+						continue
+					}
+					runNow := func() error {
+						slog.Info("catching up missed job", "job", missedJob.Name, "last_success", missedJob.LastSuccess, "missed_slot", missedJob.MissedSlot)
+						err := job.RunNow()
+						if err != nil {
+							slog.Error("failed to run missed job", "job", missedJob.Name, "error", err)
+						}
+						return err
+					}
+
+					if name, ok := strings.CutPrefix(missedJob.Name, "backup:"); ok {
+						finished := tracker.Finished(missedJob.Name)
+						if runNow() == nil {
+							backupsFinished[name] = finished
+						}
+						continue
+					}
+					name, _ := strings.CutPrefix(missedJob.Name, "s3:")
+					finished, ok := backupsFinished[name]
+					if !ok {
+						runNow()
+						continue
+					}
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						select {
+						case <-finished:
+							runNow()
+						case <-jobCtx.Done():
+						}
+					}()
+				}
+			}
+		}()
+	}
+
 	// Start http server
 	server := http.Server{
 		Addr: ":2112",
@@ -134,12 +283,45 @@ func main() {
 	signal.Notify(osSignal, syscall.SIGINT, syscall.SIGTERM)
 
 	<-osSignal
-	slog.Info("received termination signal, shutting down")
+	slog.Info("received termination signal, shutting down", "timeout", c.ShutdownTimeout)
+
+	// Let running jobs finish within the shutdown timeout and cancel them afterwards
+	interrupted := []api.RunningJob{}
+	interruptMu := sync.Mutex{}
+	timer := time.AfterFunc(c.ShutdownTimeout, func() {
+		interruptMu.Lock()
+		defer interruptMu.Unlock()
+		interrupted = tracker.Running()
+		for _, job := range interrupted {
+			slog.Warn("shutdown timeout reached, cancelling job", "job", job.Name, "started_at", job.StartedAt)
+		}
+		cancelJobs()
+	})
 
 	// Stop scheduler
-	scheduler.Shutdown()
+	if err := scheduler.Shutdown(); err != nil {
+		slog.Error("failed to wait for running jobs", "error", err)
+	}
+	timer.Stop()
+	// S3 catch-ups still waiting for their backup give up
+	cancelJobs()
 	slog.Info("scheduler stopped")
 
+	// Cancelled jobs may leave a stale lock behind if restic did not exit in time
+	interruptMu.Lock()
+	if len(interrupted) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), jobCancelTimeout)
+		for _, r := range repos {
+			if err := r.Unlock(ctx); err != nil {
+				slog.Error("failed to unlock restic repository", "repository", r.Name(), "error", err)
+			} else {
+				slog.Info("restic repository unlocked", "repository", r.Name())
+			}
+		}
+		cancel()
+	}
+	interruptMu.Unlock()
+
 	// Stop http server
 	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
 	defer cancel()
@@ -149,5 +331,8 @@ func main() {
 
 	// Run until shutdown is complete
 	wg.Wait()
+
+	// Deliver the notifications of the last runs
+	n.Close()
 	slog.Info("AutoRestic gracefully stopped")
 }
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+	"github.com/google/uuid"
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/docker"
+	"github.com/korbiniankuhn/auto-restic/internal/progress"
+	"github.com/korbiniankuhn/auto-restic/internal/restic"
+	"github.com/korbiniankuhn/auto-restic/internal/s3"
+)
+
+type RunningJob struct {
+	Name      string    `json:"name"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+type ScheduledJob struct {
+	Name    string    `json:"name"`
+	LastRun time.Time `json:"last_run"`
+	NextRun time.Time `json:"next_run"`
+}
+
+type Status struct {
+	Running   []RunningJob   `json:"running"`
+	Queued    int            `json:"queued"`
+	Scheduled []ScheduledJob `json:"scheduled"`
+}
+
+type Backup struct {
+	Name       string   `json:"name"`
+	Repository string   `json:"repository"`
+	Paths      []string `json:"paths"`
+	Cron       string   `json:"cron"`
+	S3Cron     string   `json:"s3_cron"`
+}
+
+type Error struct {
+	Error string `json:"error"`
+}
+
+// JobTracker keeps track of the jobs currently executed by the scheduler
+type JobTracker struct {
+	mu      sync.Mutex
+	running map[uuid.UUID]RunningJob
+	waiters map[string][]*jobWaiter
+}
+
+// jobWaiter waits for the next run of a job, a run that is already running does not count
+type jobWaiter struct {
+	started  bool
+	finished chan struct{}
+}
+
+func NewJobTracker() *JobTracker {
+	return &JobTracker{
+		running: map[uuid.UUID]RunningJob{},
+		waiters: map[string][]*jobWaiter{},
+	}
+}
+
+func (t *JobTracker) BeforeJobRuns(id uuid.UUID, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running[id] = RunningJob{Name: name, StartedAt: time.Now()}
+	for _, w := range t.waiters[name] {
+		w.started = true
+	}
+}
+
+func (t *JobTracker) AfterJobRuns(id uuid.UUID, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.running, id)
+
+	waiting := []*jobWaiter{}
+	for _, w := range t.waiters[name] {
+		if w.started {
+			close(w.finished)
+		} else {
+			waiting = append(waiting, w)
+		}
+	}
+	t.waiters[name] = waiting
+}
+
+// Finished returns a channel that is closed when the next run of the job with the name finished
+func (t *JobTracker) Finished(name string) <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := &jobWaiter{finished: make(chan struct{})}
+	t.waiters[name] = append(t.waiters[name], w)
+	return w.finished
+}
+
+func (t *JobTracker) Running() []RunningJob {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	jobs := make([]RunningJob, 0, len(t.running))
+	for _, job := range t.running {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.Before(jobs[j].StartedAt)
+	})
+	return jobs
+}
+
+type Server struct {
+	config    config.Config
+	repos     restic.Repositories
+	s3        *s3.S3
+	scheduler gocron.Scheduler
+	tracker   *JobTracker
+	progress  *progress.Tracker
+}
+
+func New(c config.Config, repos restic.Repositories, s *s3.S3, scheduler gocron.Scheduler, tracker *JobTracker, p *progress.Tracker) *Server {
+	return &Server{
+		config:    c,
+		repos:     repos,
+		s3:        s,
+		scheduler: scheduler,
+		tracker:   tracker,
+		progress:  p,
+	}
+}
+
+func (a *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/backups", a.listBackups)
+	mux.HandleFunc("GET /api/snapshots", a.listSnapshots)
+	mux.HandleFunc("GET /api/s3/objects", a.listS3Objects)
+	mux.HandleFunc("GET /api/status", a.getStatus)
+	mux.HandleFunc("GET /api/progress", a.getProgress)
+	mux.HandleFunc("POST /api/jobs/backup/{name}", a.runJob("backup:"))
+	mux.HandleFunc("POST /api/jobs/s3/{name}", a.runJob("s3:"))
+	mux.HandleFunc("POST /api/jobs/check", a.runJob("check"))
+	mux.HandleFunc("POST /api/jobs/prune", a.runJob("prune"))
+
+	return a.authenticate(mux)
+}
+
+func (a *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.config.API.Token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, Error{Error: "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Server) listBackups(w http.ResponseWriter, r *http.Request) {
+	backups := make([]Backup, 0, len(a.config.Backups))
+	for _, b := range a.config.Backups {
+		backups = append(backups, Backup{
+			Name:       b.Name,
+			Repository: b.Repository,
+			Paths:      b.Paths,
+			Cron:       b.Cron,
+			S3Cron:     b.S3Cron,
+		})
+	}
+	writeJSON(w, http.StatusOK, backups)
+}
+
+func (a *Server) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	repos := a.repos
+	if name := r.URL.Query().Get("repository"); name != "" {
+		repo, err := a.repos.Get(name)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, Error{Error: err.Error()})
+			return
+		}
+		repos = restic.Repositories{repo}
+	}
+
+	snapshots, err := repos.ListSnapshots(r.Context())
+	if err != nil {
+		slog.Error("failed to list snapshots", "error", err)
+		writeJSON(w, http.StatusInternalServerError, Error{Error: "failed to list snapshots"})
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshots)
+}
+
+func (a *Server) listS3Objects(w http.ResponseWriter, r *http.Request) {
+	objects, err := a.s3.ListObjects(r.Context())
+	if err != nil {
+		slog.Error("failed to list s3 objects", "error", err)
+		writeJSON(w, http.StatusInternalServerError, Error{Error: "failed to list s3 objects"})
+		return
+	}
+	writeJSON(w, http.StatusOK, objects)
+}
+
+func (a *Server) getStatus(w http.ResponseWriter, r *http.Request) {
+	status := Status{
+		Running:   a.tracker.Running(),
+		Queued:    a.scheduler.JobsWaitingInQueue(),
+		Scheduled: []ScheduledJob{},
+	}
+
+	for _, job := range a.scheduler.Jobs() {
+		lastRun, _ := job.LastRun()
+		nextRun, _ := job.NextRun()
+		status.Scheduled = append(status.Scheduled, ScheduledJob{
+			Name:    job.Name(),
+			LastRun: lastRun,
+			NextRun: nextRun,
+		})
+	}
+	sort.Slice(status.Scheduled, func(i, j int) bool {
+		return status.Scheduled[i].Name < status.Scheduled[j].Name
+	})
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (a *Server) getProgress(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.progress.List())
+}
+
+// runJob queues a run of an existing scheduler job, so on demand runs wait
+// for running jobs like scheduled ones
+func (a *Server) runJob(prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := prefix + r.PathValue("name")
+
+		for _, job := range a.scheduler.Jobs() {
+			if job.Name() != name {
+				continue
+			}
+
+			if err := job.RunNow(); err != nil {
+				slog.Error("failed to trigger job", "job", name, "error", err)
+				writeJSON(w, http.StatusInternalServerError, Error{Error: "failed to trigger job"})
+				return
+			}
+
+			slog.Info("triggered job", "job", name)
+			writeJSON(w, http.StatusAccepted, ScheduledJob{Name: name})
+			return
+		}
+
+		// Discovered backups have no job of their own, they run together in the discovered job
+		if a.isDiscovered(r.Context(), prefix, r.PathValue("name")) {
+			writeJSON(w, http.StatusBadRequest, Error{Error: "backup " + r.PathValue("name") + " is discovered from docker labels and runs in job " + prefix + "discovered"})
+			return
+		}
+
+		writeJSON(w, http.StatusNotFound, Error{Error: "job not found: " + name})
+	}
+}
+
+func (a *Server) isDiscovered(ctx context.Context, prefix, name string) bool {
+	if !a.config.Docker.Discovery || (prefix != "backup:" && prefix != "s3:") {
+		return false
+	}
+	backups, _, err := docker.New(a.config.Docker.Socket).DiscoverBackups(ctx, a.config)
+	if err != nil {
+		slog.Warn("failed to discover backups from docker labels", "error", err)
+		return false
+	}
+	return slices.ContainsFunc(backups, func(b config.BackupConfig) bool { return b.Name == name })
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+	"github.com/korbiniankuhn/auto-restic/internal/progress"
+	"github.com/korbiniankuhn/auto-restic/internal/restic"
+)
+
+const token = "secret"
+
+// newTestServer serves the API with a scheduler job backup:app that signals its runs on the channel
+func newTestServer(t *testing.T) (*httptest.Server, *progress.Tracker, <-chan struct{}) {
+	t.Helper()
+	tracker := NewJobTracker()
+	scheduler, err := gocron.NewScheduler(gocron.WithGlobalJobOptions(gocron.WithEventListeners(
+		gocron.BeforeJobRuns(tracker.BeforeJobRuns),
+		gocron.AfterJobRuns(tracker.AfterJobRuns),
+	)))
+	if err != nil {
+		t.Fatalf("failed to create scheduler: %v", err)
+	}
+	ran := make(chan struct{}, 1)
+	_, err = scheduler.NewJob(
+		gocron.CronJob("0 2 * * *", false),
+		gocron.NewTask(func() { ran <- struct{}{} }),
+		gocron.WithName("backup:app"),
+	)
+	if err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	scheduler.Start()
+	t.Cleanup(func() { scheduler.Shutdown() })
+
+	c := config.Config{API: config.APIConfig{Token: token}}
+	p := progress.NewTracker(metrics.NewMetrics())
+	server := httptest.NewServer(New(c, restic.Repositories{}, nil, scheduler, tracker, p).Handler())
+	t.Cleanup(server.Close)
+	return server, p, ran
+}
+
+func request(t *testing.T, server *httptest.Server, method, path, authorization string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("content type = %s, want application/json", contentType)
+	}
+	return resp, body
+}
+
+func TestAuthentication(t *testing.T) {
+	server, _, _ := newTestServer(t)
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{name: "missing token", status: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer wrong", status: http.StatusUnauthorized},
+		{name: "token without bearer", authorization: token, status: http.StatusUnauthorized},
+		{name: "valid token", authorization: "Bearer " + token, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := request(t, server, http.MethodGet, "/api/backups", tt.authorization)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d: %s", resp.StatusCode, tt.status, body)
+			}
+			if tt.status == http.StatusUnauthorized {
+				var e Error
+				if err := json.Unmarshal(body, &e); err != nil || e.Error != "unauthorized" {
+					t.Errorf("body = %s, want an unauthorized error", body)
+				}
+			}
+		})
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	server, _, ran := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{name: "unknown backup", path: "/api/jobs/backup/unknown", status: http.StatusNotFound},
+		{name: "unknown s3 job", path: "/api/jobs/s3/app", status: http.StatusNotFound},
+		{name: "job not scheduled", path: "/api/jobs/check", status: http.StatusNotFound},
+		{name: "backup", path: "/api/jobs/backup/app", status: http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := request(t, server, http.MethodPost, tt.path, "Bearer "+token)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.status, body)
+			}
+			if tt.status != http.StatusAccepted {
+				return
+			}
+
+			var job ScheduledJob
+			if err := json.Unmarshal(body, &job); err != nil || job.Name != "backup:app" {
+				t.Errorf("body = %s, want job backup:app", body)
+			}
+			select {
+			case <-ran:
+			case <-time.After(5 * time.Second):
+				t.Error("triggered job did not run")
+			}
+		})
+	}
+}
+
+func TestListSnapshotsUnknownRepository(t *testing.T) {
+	server, _, _ := newTestServer(t)
+
+	resp, body := request(t, server, http.MethodGet, "/api/snapshots?repository=offsite", "Bearer "+token)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	var e Error
+	if err := json.Unmarshal(body, &e); err != nil || e.Error != "unknown repository: offsite" {
+		t.Errorf("body = %s, want the unknown repository", body)
+	}
+}
+
+func TestGetProgress(t *testing.T) {
+	server, p, _ := newTestServer(t)
+
+	// No running jobs are an empty list, not null
+	if _, body := request(t, server, http.MethodGet, "/api/progress", "Bearer "+token); string(body) != "[]" {
+		t.Errorf("body = %s, want []", body)
+	}
+
+	p.Start("backup", "app")
+	p.Update(progress.Progress{Job: "backup", Backup: "app", PercentDone: 0.5, BytesDone: 512, TotalBytes: 1024, FilesDone: 1, TotalFiles: 2, SecondsRemaining: 10})
+
+	resp, body := request(t, server, http.MethodGet, "/api/progress", "Bearer "+token)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var list []map[string]any
+	if err := json.Unmarshal(body, &list); err != nil || len(list) != 1 {
+		t.Fatalf("body = %s, want one progress", body)
+	}
+	keys := []string{}
+	for key := range list[0] {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	want := []string{"backup", "bytes_done", "files_done", "job", "percent_done", "seconds_remaining", "started_at", "total_bytes", "total_files", "updated_at"}
+	if !slices.Equal(keys, want) {
+		t.Errorf("keys = %q, want %q", keys, want)
+	}
+	if list[0]["job"] != "backup" || list[0]["backup"] != "app" || list[0]["percent_done"] != 0.5 || list[0]["bytes_done"] != 512.0 {
+		t.Errorf("progress = %v, want backup app at 50%% with 512 bytes done", list[0])
+	}
+}
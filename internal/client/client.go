@@ -0,0 +1,108 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/api"
+	"github.com/korbiniankuhn/auto-restic/internal/progress"
+	"github.com/korbiniankuhn/auto-restic/internal/restic"
+	"github.com/korbiniankuhn/auto-restic/internal/s3"
+)
+
+// Client talks to the REST API of a running server
+type Client struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+func New(serverURL, token string) *Client {
+	return &Client{
+		url:   strings.TrimSuffix(serverURL, "/"),
+		token: token,
+		http:  &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+func (c *Client) do(method, path string, out any) error {
+	req, err := http.NewRequest(method, c.url+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var apiErr api.Error
+		body, _ := io.ReadAll(res.Body)
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("server responded with %d: %s", res.StatusCode, apiErr.Error)
+		}
+		return fmt.Errorf("server responded with %d: %s", res.StatusCode, body)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) ListBackups() ([]api.Backup, error) {
+	var backups []api.Backup
+	err := c.do(http.MethodGet, "/api/backups", &backups)
+	return backups, err
+}
+
+// ListSnapshots lists the snapshots of the given repository or of all repositories if empty
+func (c *Client) ListSnapshots(repository string) ([]restic.Snapshot, error) {
+	path := "/api/snapshots"
+	if repository != "" {
+		path += "?repository=" + url.QueryEscape(repository)
+	}
+	var snapshots []restic.Snapshot
+	err := c.do(http.MethodGet, path, &snapshots)
+	return snapshots, err
+}
+
+func (c *Client) ListS3Objects() ([]s3.S3Object, error) {
+	var objects []s3.S3Object
+	err := c.do(http.MethodGet, "/api/s3/objects", &objects)
+	return objects, err
+}
+
+func (c *Client) GetStatus() (api.Status, error) {
+	var status api.Status
+	err := c.do(http.MethodGet, "/api/status", &status)
+	return status, err
+}
+
+func (c *Client) GetProgress() ([]progress.Progress, error) {
+	var list []progress.Progress
+	err := c.do(http.MethodGet, "/api/progress", &list)
+	return list, err
+}
+
+// RunJob triggers a job (backup, s3, check, prune), name is required for backup and s3
+func (c *Client) RunJob(job, name string) error {
+	path := "/api/jobs/" + url.PathEscape(job)
+	if name != "" {
+		path += "/" + url.PathEscape(name)
+	}
+	return c.do(http.MethodPost, path, nil)
+}
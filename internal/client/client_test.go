@@ -0,0 +1,162 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestServer records the requests and responds with the body of the first matching route
+func newTestServer(t *testing.T, routes map[string]string) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		mu.Unlock()
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unauthorized"}`))
+			return
+		}
+		body, ok := routes[r.Method+" "+r.URL.RequestURI()]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"job not found"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	tests := []struct {
+		name string
+		job  string
+		arg  string
+		want string
+	}{
+		{name: "backup", job: "backup", arg: "app", want: "POST /api/jobs/backup/app"},
+		{name: "s3", job: "s3", arg: "app", want: "POST /api/jobs/s3/app"},
+		{name: "check", job: "check", want: "POST /api/jobs/check"},
+		{name: "prune", job: "prune", want: "POST /api/jobs/prune"},
+		{name: "escaped name", job: "backup", arg: "app/db", want: "POST /api/jobs/backup/app%2Fdb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newTestServer(t, map[string]string{tt.want: `{"name":"job"}`})
+			// A trailing slash of the server URL is not doubled
+			if err := New(server.URL+"/", "secret").RunJob(tt.job, tt.arg); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := requests(); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("requests = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetStatus(t *testing.T) {
+	server, _ := newTestServer(t, map[string]string{
+		"GET /api/status": `{"running":[{"name":"backup:app","started_at":"2024-06-12T02:00:00Z"}],"queued":2,"scheduled":[{"name":"prune"}]}`,
+	})
+
+	status, err := New(server.URL, "secret").GetStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(status.Running) != 1 || status.Running[0].Name != "backup:app" || status.Running[0].StartedAt.Hour() != 2 {
+		t.Errorf("running = %+v, want backup:app started at 02:00", status.Running)
+	}
+	if status.Queued != 2 || len(status.Scheduled) != 1 || status.Scheduled[0].Name != "prune" {
+		t.Errorf("status = %+v, want 2 queued and prune scheduled", status)
+	}
+}
+
+func TestGetProgress(t *testing.T) {
+	server, _ := newTestServer(t, map[string]string{
+		"GET /api/progress": `[{"job":"backup","backup":"app","percent_done":0.5,"bytes_done":512,"seconds_remaining":10}]`,
+	})
+
+	list, err := New(server.URL, "secret").GetProgress()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list) != 1 || list[0].Job != "backup" || list[0].Backup != "app" || list[0].PercentDone != 0.5 || list[0].BytesDone != 512 || list[0].SecondsRemaining != 10 {
+		t.Errorf("progress = %+v, want backup app at 50%%", list)
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	server, requests := newTestServer(t, map[string]string{
+		"GET /api/snapshots":                         `[]`,
+		"GET /api/snapshots?repository=off+site%2F1": `[{"id":"abc","repository":"off site/1"}]`,
+	})
+	c := New(server.URL, "secret")
+
+	if _, err := c.ListSnapshots(""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snapshots, err := c.ListSnapshots("off site/1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].ID != "abc" {
+		t.Errorf("snapshots = %+v, want abc", snapshots)
+	}
+	if got := requests(); len(got) != 2 || got[1] != "GET /api/snapshots?repository=off+site%2F1" {
+		t.Errorf("requests = %q, want the escaped repository", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		path  string
+		body  string
+		err   string
+	}{
+		{name: "wrong token", token: "wrong", err: "server responded with 401: unauthorized"},
+		{name: "api error", token: "secret", err: "server responded with 404: job not found"},
+		{name: "invalid response", token: "secret", path: "GET /api/status", body: "bad gateway", err: "failed to decode response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := map[string]string{}
+			if tt.path != "" {
+				routes[tt.path] = tt.body
+			}
+			server, _ := newTestServer(t, routes)
+			_, err := New(server.URL, tt.token).GetStatus()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestErrorWithoutJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	t.Cleanup(server.Close)
+
+	err := New(server.URL, "secret").RunJob("check", "")
+	if err == nil || err.Error() != "server responded with 502: bad gateway\n" {
+		t.Errorf("error = %q, want the plain body", err)
+	}
+}
@@ -4,13 +4,18 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 
 	"github.com/spf13/viper"
 )
 
+// DefaultRepository is the name of the repository of the restic section
+const DefaultRepository = "default"
+
 type LogFormat string
 
 const (
@@ -29,6 +34,13 @@ func (f *LogFormat) UnmarshalText(text []byte) error {
 	}
 }
 
+type S3DumpMode string
+
+const (
+	S3DumpModeStream  S3DumpMode = "stream"
+	S3DumpModeRestore S3DumpMode = "restore"
+)
+
 type LoggingConfig struct {
 	Level     string     `mapstructure:"level"`
 	Format    LogFormat  `mapstructure:"format"`
@@ -52,11 +64,87 @@ func (c *LoggingConfig) Parse() error {
 }
 
 type ResticConfig struct {
-	Password    string `mapstructure:"password"`
-	Repository  string `mapstructure:"repository"`
-	KeepDaily   int    `mapstructure:"keep_daily"`
-	KeepWeekly  int    `mapstructure:"keep_weekly"`
-	KeepMonthly int    `mapstructure:"keep_monthly"`
+	Password        string             `mapstructure:"password"`
+	PasswordFile    string             `mapstructure:"password_file"`
+	PasswordCommand string             `mapstructure:"password_command"`
+	Repository      string             `mapstructure:"repository"`
+	Rest            *RestBackendConfig `mapstructure:"rest"`
+	SFTP            *SFTPBackendConfig `mapstructure:"sftp"`
+	S3              *S3BackendConfig   `mapstructure:"s3"`
+	KeepDaily       int                `mapstructure:"keep_daily"`
+	KeepWeekly      int                `mapstructure:"keep_weekly"`
+	KeepMonthly     int                `mapstructure:"keep_monthly"`
+}
+
+// RepositoryConfig is a named restic repository, the restic section is the repository "default"
+type RepositoryConfig struct {
+	Name       string `mapstructure:"name"`
+	Repository string `mapstructure:"repository"`
+	Password   string `mapstructure:"password"`
+	// Name of the environment variable with the password
+	PasswordEnv string `mapstructure:"password_env"`
+	// Alternatives to the password, passed to restic as RESTIC_PASSWORD_FILE and RESTIC_PASSWORD_COMMAND
+	PasswordFile    string `mapstructure:"password_file"`
+	PasswordCommand string `mapstructure:"password_command"`
+	// Typed remote backends set the repository url and the credentials of restic
+	Rest *RestBackendConfig `mapstructure:"rest"`
+	SFTP *SFTPBackendConfig `mapstructure:"sftp"`
+	S3   *S3BackendConfig   `mapstructure:"s3"`
+	// Retention of backups without their own, defaults to the keep_* values of the restic section
+	KeepDaily   int `mapstructure:"keep_daily"`
+	KeepWeekly  int `mapstructure:"keep_weekly"`
+	KeepMonthly int `mapstructure:"keep_monthly"`
+}
+
+// RestBackendConfig is a repository on a rest-server
+type RestBackendConfig struct {
+	URL      string `mapstructure:"url"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// Name of the environment variable with the password
+	PasswordEnv string `mapstructure:"password_env"`
+}
+
+// SFTPBackendConfig is a repository on a ssh server, keys and known hosts come from the ssh config
+type SFTPBackendConfig struct {
+	Host string `mapstructure:"host"`
+	Port int    `mapstructure:"port"`
+	User string `mapstructure:"user"`
+	Path string `mapstructure:"path"`
+}
+
+// S3BackendConfig is a repository in an S3 bucket, e.g. on AWS or MinIO
+type S3BackendConfig struct {
+	// Defaults to s3.amazonaws.com, e.g. http://minio:9000 for other servers
+	Endpoint  string `mapstructure:"endpoint"`
+	Bucket    string `mapstructure:"bucket"`
+	Path      string `mapstructure:"path"`
+	Region    string `mapstructure:"region"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	// Names of the environment variables with the keys
+	AccessKeyEnv string `mapstructure:"access_key_env"`
+	SecretKeyEnv string `mapstructure:"secret_key_env"`
+}
+
+// Env returns the environment variables of restic with the credentials of the backend
+func (r RepositoryConfig) Env() []string {
+	env := []string{}
+	switch {
+	case r.Rest != nil:
+		if r.Rest.Username != "" {
+			env = append(env, "RESTIC_REST_USERNAME="+r.Rest.Username)
+		}
+		if r.Rest.Password != "" {
+			env = append(env, "RESTIC_REST_PASSWORD="+r.Rest.Password)
+		}
+	case r.S3 != nil:
+		env = append(env, "AWS_ACCESS_KEY_ID="+r.S3.AccessKey, "AWS_SECRET_ACCESS_KEY="+r.S3.SecretKey)
+		if r.S3.Region != "" {
+			env = append(env, "AWS_DEFAULT_REGION="+r.S3.Region)
+		}
+	}
+	return env
 }
 
 type CronConfig struct {
@@ -67,30 +155,351 @@ type CronConfig struct {
 	Metrics string `mapstructure:"metrics"`
 }
 
+type TimeoutsConfig struct {
+	Backup time.Duration `mapstructure:"backup"`
+	S3     time.Duration `mapstructure:"s3"`
+	Check  time.Duration `mapstructure:"check"`
+	Prune  time.Duration `mapstructure:"prune"`
+}
+
+type RetryConfig struct {
+	MaxAttempts int           `mapstructure:"max_attempts"`
+	BaseDelay   time.Duration `mapstructure:"base_delay"`
+	MaxDelay    time.Duration `mapstructure:"max_delay"`
+	Jitter      float64       `mapstructure:"jitter"`
+}
+
+type RetriesConfig struct {
+	Backup RetryConfig `mapstructure:"backup"`
+	S3     RetryConfig `mapstructure:"s3"`
+	Check  RetryConfig `mapstructure:"check"`
+	Prune  RetryConfig `mapstructure:"prune"`
+}
+
+type ConcurrencyConfig struct {
+	// Jobs running at the same time
+	Jobs int `mapstructure:"jobs"`
+	// Backups or S3 uploads of one job running at the same time
+	Backups int `mapstructure:"backups"`
+}
+
+type CatchUpConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	MaxStaleness time.Duration `mapstructure:"max_staleness"`
+}
+
+type DatabaseType string
+
+const (
+	DatabaseTypePostgres DatabaseType = "postgres"
+	DatabaseTypeMySQL    DatabaseType = "mysql"
+	DatabaseTypeMongoDB  DatabaseType = "mongodb"
+	DatabaseTypeSQLite   DatabaseType = "sqlite"
+	DatabaseTypeRedis    DatabaseType = "redis"
+)
+
+type DatabaseConfig struct {
+	Type DatabaseType `mapstructure:"type"`
+	Host string       `mapstructure:"host"`
+	Port int          `mapstructure:"port"`
+	User string       `mapstructure:"user"`
+	// Name of the environment variable with the password
+	PasswordEnv string   `mapstructure:"password_env"`
+	Database    string   `mapstructure:"database"`
+	Path        string   `mapstructure:"path"`
+	Options     []string `mapstructure:"options"`
+}
+
 type S3Config struct {
-	AccessKey  string `mapstructure:"access_key"`
-	SecretKey  string `mapstructure:"secret_key"`
-	Endpoint   string `mapstructure:"endpoint"`
-	Bucket     string `mapstructure:"bucket"`
-	Passphrase string `mapstructure:"passphrase"`
+	AccessKey  string     `mapstructure:"access_key"`
+	SecretKey  string     `mapstructure:"secret_key"`
+	Endpoint   string     `mapstructure:"endpoint"`
+	Bucket     string     `mapstructure:"bucket"`
+	Passphrase string     `mapstructure:"passphrase"`
+	DumpMode   S3DumpMode `mapstructure:"dump_mode"`
+}
+
+type RetentionConfig struct {
+	KeepLast    int    `mapstructure:"keep_last"`
+	KeepHourly  int    `mapstructure:"keep_hourly"`
+	KeepDaily   int    `mapstructure:"keep_daily"`
+	KeepWeekly  int    `mapstructure:"keep_weekly"`
+	KeepMonthly int    `mapstructure:"keep_monthly"`
+	KeepYearly  int    `mapstructure:"keep_yearly"`
+	KeepWithin  string `mapstructure:"keep_within"`
+}
+
+func (c RetentionConfig) IsEmpty() bool {
+	return c == RetentionConfig{}
+}
+
+type PingConfig struct {
+	Start   string `mapstructure:"start"`
+	Success string `mapstructure:"success"`
+	Fail    string `mapstructure:"fail"`
+}
+
+type PingsConfig struct {
+	Backup PingConfig `mapstructure:"backup"`
+	S3     PingConfig `mapstructure:"s3"`
+	Check  PingConfig `mapstructure:"check"`
+	Prune  PingConfig `mapstructure:"prune"`
+}
+
+type HookConfig struct {
+	Command    string        `mapstructure:"command"`
+	Timeout    time.Duration `mapstructure:"timeout"`
+	WorkingDir string        `mapstructure:"working_dir"`
+}
+
+// HooksConfig are run once around a backup including its retries, post_failure and finally also
+// run after a cancelled or timed out backup
+type HooksConfig struct {
+	Pre         []HookConfig `mapstructure:"pre"`
+	PostSuccess []HookConfig `mapstructure:"post_success"`
+	PostFailure []HookConfig `mapstructure:"post_failure"`
+	Finally     []HookConfig `mapstructure:"finally"`
+}
+
+type HookFailureMode string
+
+const (
+	HookFailureAbort    HookFailureMode = "abort"
+	HookFailureContinue HookFailureMode = "continue"
+)
+
+// JobHookConfig are run once before and after all backups of a job run
+type JobHookConfig struct {
+	Pre       []HookConfig    `mapstructure:"pre"`
+	Post      []HookConfig    `mapstructure:"post"`
+	OnFailure HookFailureMode `mapstructure:"on_failure"`
+}
+
+type JobHooksConfig struct {
+	Backup JobHookConfig `mapstructure:"backup"`
+	S3     JobHookConfig `mapstructure:"s3"`
+	Check  JobHookConfig `mapstructure:"check"`
+	Prune  JobHookConfig `mapstructure:"prune"`
 }
 
 type BackupConfig struct {
-	Name        string `mapstructure:"name"`
-	Path        string `mapstructure:"path"`
-	Exclude     string `mapstructure:"exclude"`
-	ExcludeFile string `mapstructure:"exclude_file"`
-	PreCommand  string `mapstructure:"pre_command"`
-	PostCommand string `mapstructure:"post_command"`
+	Name string `mapstructure:"name"`
+	// Name of the repository, defaults to the first one
+	Repository string `mapstructure:"repository"`
+	// Single value fields are merged into the lists on load
+	Path              string   `mapstructure:"path"`
+	Paths             []string `mapstructure:"paths"`
+	Exclude           string   `mapstructure:"exclude"`
+	Excludes          []string `mapstructure:"excludes"`
+	IExcludes         []string `mapstructure:"iexcludes"`
+	ExcludeFile       string   `mapstructure:"exclude_file"`
+	ExcludeFiles      []string `mapstructure:"exclude_files"`
+	ExcludeIfPresent  []string `mapstructure:"exclude_if_present"`
+	ExcludeLargerThan string   `mapstructure:"exclude_larger_than"`
+	OneFileSystem     bool     `mapstructure:"one_file_system"`
+	FilesFrom         []string `mapstructure:"files_from"`
+	// Back up the stdout of a command or a database dump instead of paths
+	Command       string          `mapstructure:"command"`
+	Database      *DatabaseConfig `mapstructure:"database"`
+	StdinFilename string          `mapstructure:"stdin_filename"`
+	// Merged into the pre and post_success hooks on load
+	PreCommand  string           `mapstructure:"pre_command"`
+	PostCommand string           `mapstructure:"post_command"`
+	Hooks       HooksConfig      `mapstructure:"hooks"`
+	Retention   *RetentionConfig `mapstructure:"retention"`
+	Cron        string           `mapstructure:"cron"`
+	S3Cron      string           `mapstructure:"s3_cron"`
+	Ping        PingConfig       `mapstructure:"ping"`
+	S3Ping      PingConfig       `mapstructure:"s3_ping"`
+	Timeout     time.Duration    `mapstructure:"timeout"`
+	S3Timeout   time.Duration    `mapstructure:"s3_timeout"`
+	// Containers that are stopped or paused during the backup and started again afterwards
+	StopContainers  []string `mapstructure:"stop_containers"`
+	PauseContainers []string `mapstructure:"pause_containers"`
+}
+
+type SMTPConfig struct {
+	Host     string   `mapstructure:"host"`
+	Port     int      `mapstructure:"port"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"`
+	To       []string `mapstructure:"to"`
+}
+
+type NotificationConfig struct {
+	Name     string            `mapstructure:"name"`
+	Type     string            `mapstructure:"type"`
+	URL      string            `mapstructure:"url"`
+	Token    string            `mapstructure:"token"`
+	Headers  map[string]string `mapstructure:"headers"`
+	SMTP     SMTPConfig        `mapstructure:"smtp"`
+	Jobs     []string          `mapstructure:"jobs"`
+	Events   []string          `mapstructure:"events"`
+	Severity string            `mapstructure:"severity"`
+}
+
+type DockerConfig struct {
+	Socket    string `mapstructure:"socket"`
+	Discovery bool   `mapstructure:"discovery"`
+}
+
+type APIConfig struct {
+	Token string `mapstructure:"token"`
 }
 
 type Config struct {
-	Logging        LoggingConfig  `mapstructure:"logging"`
-	Restic         ResticConfig   `mapstructure:"restic"`
-	Cron           CronConfig     `mapstructure:"cron"`
-	S3             S3Config       `mapstructure:"s3"`
-	MetricsEnabled bool           `mapstructure:"metrics_enabled"`
-	Backups        []BackupConfig `mapstructure:"backups"`
+	Logging         LoggingConfig        `mapstructure:"logging"`
+	Restic          ResticConfig         `mapstructure:"restic"`
+	Repositories    []RepositoryConfig   `mapstructure:"repositories"`
+	Cron            CronConfig           `mapstructure:"cron"`
+	S3              S3Config             `mapstructure:"s3"`
+	MetricsEnabled  bool                 `mapstructure:"metrics_enabled"`
+	DataDir         string               `mapstructure:"data_dir"`
+	Backups         []BackupConfig       `mapstructure:"backups"`
+	Notifications   []NotificationConfig `mapstructure:"notifications"`
+	Pings           PingsConfig          `mapstructure:"pings"`
+	API             APIConfig            `mapstructure:"api"`
+	Docker          DockerConfig         `mapstructure:"docker"`
+	Timeouts        TimeoutsConfig       `mapstructure:"timeouts"`
+	Retries         RetriesConfig        `mapstructure:"retries"`
+	CatchUp         CatchUpConfig        `mapstructure:"catch_up"`
+	Concurrency     ConcurrencyConfig    `mapstructure:"concurrency"`
+	JobHooks        JobHooksConfig       `mapstructure:"job_hooks"`
+	ShutdownTimeout time.Duration        `mapstructure:"shutdown_timeout"`
+}
+
+func (c Config) GetRepository(name string) (RepositoryConfig, bool) {
+	for _, repository := range c.Repositories {
+		if repository.Name == name {
+			return repository, true
+		}
+	}
+	return RepositoryConfig{}, false
+}
+
+// validate checks the database config and sets the default host and port of the type
+func (d *DatabaseConfig) validate() error {
+	ports := map[DatabaseType]int{
+		DatabaseTypePostgres: 5432,
+		DatabaseTypeMySQL:    3306,
+		DatabaseTypeMongoDB:  27017,
+		DatabaseTypeRedis:    6379,
+	}
+
+	switch d.Type {
+	case DatabaseTypeSQLite:
+		if d.Path == "" {
+			return fmt.Errorf("path is required for type sqlite")
+		}
+	case DatabaseTypePostgres, DatabaseTypeMySQL, DatabaseTypeMongoDB, DatabaseTypeRedis:
+		if d.Host == "" {
+			d.Host = "localhost"
+		}
+		if d.Port == 0 {
+			d.Port = ports[d.Type]
+		}
+		if d.User == "" && (d.Type == DatabaseTypePostgres || d.Type == DatabaseTypeMySQL) {
+			return fmt.Errorf("user is required for type %s", d.Type)
+		}
+	default:
+		return fmt.Errorf("invalid type: %s", d.Type)
+	}
+
+	if d.PasswordEnv != "" && os.Getenv(d.PasswordEnv) == "" {
+		return fmt.Errorf("environment variable %s is not set", d.PasswordEnv)
+	}
+
+	return nil
+}
+
+// validate resolves the password and the repository url of a typed backend
+func (r *RepositoryConfig) validate() error {
+	backends := 0
+	if r.Rest != nil {
+		backends++
+		if r.Rest.URL == "" {
+			return fmt.Errorf("rest url is required")
+		}
+		if r.Rest.PasswordEnv != "" {
+			r.Rest.Password = os.Getenv(r.Rest.PasswordEnv)
+		}
+		if r.Rest.Username != "" && r.Rest.Password == "" {
+			return fmt.Errorf("rest password is required with a username")
+		}
+		r.Repository = "rest:" + r.Rest.URL
+	}
+	if r.SFTP != nil {
+		backends++
+		if r.SFTP.Host == "" || r.SFTP.Path == "" {
+			return fmt.Errorf("sftp host and path are required")
+		}
+		host := r.SFTP.Host
+		if r.SFTP.User != "" {
+			host = r.SFTP.User + "@" + host
+		}
+		// A port needs the url form, where absolute paths start with a double slash
+		if r.SFTP.Port != 0 {
+			r.Repository = fmt.Sprintf("sftp://%s:%d/%s", host, r.SFTP.Port, r.SFTP.Path)
+		} else {
+			r.Repository = fmt.Sprintf("sftp:%s:%s", host, r.SFTP.Path)
+		}
+	}
+	if r.S3 != nil {
+		backends++
+		if r.S3.Bucket == "" {
+			return fmt.Errorf("s3 bucket is required")
+		}
+		if r.S3.AccessKeyEnv != "" {
+			r.S3.AccessKey = os.Getenv(r.S3.AccessKeyEnv)
+		}
+		if r.S3.SecretKeyEnv != "" {
+			r.S3.SecretKey = os.Getenv(r.S3.SecretKeyEnv)
+		}
+		if r.S3.AccessKey == "" || r.S3.SecretKey == "" {
+			return fmt.Errorf("s3 access key and secret key are required")
+		}
+		if r.S3.Endpoint == "" {
+			r.S3.Endpoint = "s3.amazonaws.com"
+		}
+		r.Repository = "s3:" + strings.TrimSuffix(r.S3.Endpoint, "/") + "/" + r.S3.Bucket
+		if path := strings.Trim(r.S3.Path, "/"); path != "" {
+			r.Repository += "/" + path
+		}
+	}
+	if backends > 1 {
+		return fmt.Errorf("only one of rest, sftp and s3 can be set")
+	}
+	if r.Repository == "" {
+		return fmt.Errorf("repository url is required")
+	}
+
+	if r.PasswordEnv != "" {
+		r.Password = os.Getenv(r.PasswordEnv)
+	}
+	passwords := 0
+	for _, password := range []string{r.Password, r.PasswordFile, r.PasswordCommand} {
+		if password != "" {
+			passwords++
+		}
+	}
+	if passwords != 1 {
+		return fmt.Errorf("exactly one of password, password_file and password_command is required")
+	}
+
+	return nil
+}
+
+func validateHooks(hooks []HookConfig) error {
+	for _, hook := range hooks {
+		if hook.Command == "" {
+			return fmt.Errorf("hook command is required")
+		}
+		if hook.Timeout < 0 {
+			return fmt.Errorf("hook timeout of %s must not be negative", hook.Command)
+		}
+	}
+	return nil
 }
 
 func Get() (Config, error) {
@@ -110,6 +519,8 @@ func Get() (Config, error) {
 	_ = v.BindEnv("logging.level")
 	_ = v.BindEnv("logging.format")
 	_ = v.BindEnv("restic.password")
+	_ = v.BindEnv("restic.password_file")
+	_ = v.BindEnv("restic.password_command")
 	_ = v.BindEnv("restic.repository")
 	_ = v.BindEnv("restic.keep_daily")
 	_ = v.BindEnv("restic.keep_weekly")
@@ -119,12 +530,26 @@ func Get() (Config, error) {
 	_ = v.BindEnv("cron.prune")
 	_ = v.BindEnv("cron.s3")
 	_ = v.BindEnv("cron.metrics")
+	_ = v.BindEnv("timeouts.backup")
+	_ = v.BindEnv("timeouts.s3")
+	_ = v.BindEnv("timeouts.check")
+	_ = v.BindEnv("timeouts.prune")
+	_ = v.BindEnv("shutdown_timeout")
+	_ = v.BindEnv("catch_up.enabled")
+	_ = v.BindEnv("catch_up.max_staleness")
+	_ = v.BindEnv("concurrency.jobs")
+	_ = v.BindEnv("concurrency.backups")
 	_ = v.BindEnv("metrics_enabled")
+	_ = v.BindEnv("data_dir")
+	_ = v.BindEnv("api.token")
+	_ = v.BindEnv("docker.socket")
+	_ = v.BindEnv("docker.discovery")
 	_ = v.BindEnv("s3.access_key")
 	_ = v.BindEnv("s3.secret_key")
 	_ = v.BindEnv("s3.endpoint")
 	_ = v.BindEnv("s3.bucket")
 	_ = v.BindEnv("s3.passphrase")
+	_ = v.BindEnv("s3.dump_mode")
 
 	// Default values
 	v.SetDefault("logging.level", "info")
@@ -139,6 +564,20 @@ func Get() (Config, error) {
 	v.SetDefault("cron.check", "0 2 2 * * 0")   // Every Sunday 02:02
 	v.SetDefault("cron.prune", "0 3 2 * * 0")   // Every Sunday 02:03
 	v.SetDefault("metrics_enabled", true)
+	v.SetDefault("data_dir", "./data")
+	v.SetDefault("shutdown_timeout", "1m")
+	v.SetDefault("catch_up.enabled", true)
+	v.SetDefault("concurrency.jobs", 1)
+	v.SetDefault("concurrency.backups", 1)
+	for _, job := range []string{"backup", "s3", "check", "prune"} {
+		v.SetDefault("retries."+job+".max_attempts", 1)
+		v.SetDefault("retries."+job+".base_delay", "30s")
+		v.SetDefault("retries."+job+".max_delay", "10m")
+		v.SetDefault("retries."+job+".jitter", 0.2)
+		v.SetDefault("job_hooks."+job+".on_failure", "abort")
+	}
+	v.SetDefault("s3.dump_mode", "stream")
+	v.SetDefault("docker.socket", "/var/run/docker.sock")
 
 	// Optionally load config file
 	if err := v.ReadInConfig(); err != nil {
@@ -169,8 +608,42 @@ func Get() (Config, error) {
 		slog.SetLogLoggerLevel(config.Logging.SlogLevel)
 	}
 
-	if config.Restic.Password == "" {
-		return config, fmt.Errorf("RESTIC_PASSWORD is required")
+	// The restic section is the default repository, it is optional if named repositories are configured
+	hasPassword := config.Restic.Password != "" || config.Restic.PasswordFile != "" || config.Restic.PasswordCommand != ""
+	if hasPassword || len(config.Repositories) == 0 {
+		if !hasPassword {
+			return config, fmt.Errorf("RESTIC_PASSWORD, RESTIC_PASSWORD_FILE or RESTIC_PASSWORD_COMMAND is required")
+		}
+		config.Repositories = append([]RepositoryConfig{{
+			Name:            DefaultRepository,
+			Repository:      config.Restic.Repository,
+			Password:        config.Restic.Password,
+			PasswordFile:    config.Restic.PasswordFile,
+			PasswordCommand: config.Restic.PasswordCommand,
+			Rest:            config.Restic.Rest,
+			SFTP:            config.Restic.SFTP,
+			S3:              config.Restic.S3,
+		}}, config.Repositories...)
+	}
+
+	repositories := make(map[string]bool)
+	for i, repository := range config.Repositories {
+		if repository.Name == "" {
+			return config, fmt.Errorf("repository name is required")
+		}
+		if repositories[repository.Name] {
+			return config, fmt.Errorf("duplicate repository name: %s", repository.Name)
+		}
+		repositories[repository.Name] = true
+
+		if err := config.Repositories[i].validate(); err != nil {
+			return config, fmt.Errorf("invalid repository %s: %w", repository.Name, err)
+		}
+		if repository.KeepDaily == 0 && repository.KeepWeekly == 0 && repository.KeepMonthly == 0 {
+			config.Repositories[i].KeepDaily = config.Restic.KeepDaily
+			config.Repositories[i].KeepWeekly = config.Restic.KeepWeekly
+			config.Repositories[i].KeepMonthly = config.Restic.KeepMonthly
+		}
 	}
 
 	if config.S3.AccessKey == "" {
@@ -193,28 +666,204 @@ func Get() (Config, error) {
 		return config, fmt.Errorf("S3_PASSPHRASE is required")
 	}
 
+	switch config.S3.DumpMode {
+	case S3DumpModeStream, S3DumpModeRestore:
+	default:
+		return config, fmt.Errorf("invalid s3 dump mode: %s", config.S3.DumpMode)
+	}
+
+	if config.CatchUp.MaxStaleness < 0 {
+		return config, fmt.Errorf("catch up max staleness must not be negative")
+	}
+
+	if config.Concurrency.Jobs < 1 || config.Concurrency.Backups < 1 {
+		return config, fmt.Errorf("concurrency jobs and backups must be at least 1")
+	}
+
+	if config.ShutdownTimeout < 0 {
+		return config, fmt.Errorf("shutdown timeout must not be negative")
+	}
+
+	if config.Docker.Discovery && config.Docker.Socket == "" {
+		return config, fmt.Errorf("docker socket is required for discovery")
+	}
+
+	retries := map[string]RetryConfig{
+		"backup": config.Retries.Backup,
+		"s3":     config.Retries.S3,
+		"check":  config.Retries.Check,
+		"prune":  config.Retries.Prune,
+	}
+	for job, retry := range retries {
+		if retry.MaxAttempts < 1 {
+			return config, fmt.Errorf("retries.%s.max_attempts must be at least 1", job)
+		}
+		if retry.BaseDelay < 0 || retry.MaxDelay < retry.BaseDelay {
+			return config, fmt.Errorf("retries.%s.max_delay must be at least base_delay and delays must not be negative", job)
+		}
+		if retry.Jitter < 0 || retry.Jitter > 1 {
+			return config, fmt.Errorf("retries.%s.jitter must be between 0 and 1", job)
+		}
+	}
+
+	jobHooks := map[string]JobHookConfig{
+		"backup": config.JobHooks.Backup,
+		"s3":     config.JobHooks.S3,
+		"check":  config.JobHooks.Check,
+		"prune":  config.JobHooks.Prune,
+	}
+	for job, hooks := range jobHooks {
+		switch hooks.OnFailure {
+		case HookFailureAbort, HookFailureContinue:
+		default:
+			return config, fmt.Errorf("invalid job_hooks.%s.on_failure: %s", job, hooks.OnFailure)
+		}
+		if err := validateHooks(slices.Concat(hooks.Pre, hooks.Post)); err != nil {
+			return config, fmt.Errorf("invalid job_hooks.%s: %w", job, err)
+		}
+	}
+
 	// Validate backup configurations
 	names := make(map[string]bool)
-	for _, backup := range config.Backups {
-		if backup.Path == "" {
+	for i, backup := range config.Backups {
+		if backup.Path != "" {
+			backup.Paths = append([]string{backup.Path}, backup.Paths...)
+		}
+		if backup.Exclude != "" {
+			backup.Excludes = append([]string{backup.Exclude}, backup.Excludes...)
+		}
+		if backup.ExcludeFile != "" {
+			backup.ExcludeFiles = append([]string{backup.ExcludeFile}, backup.ExcludeFiles...)
+		}
+		if backup.PreCommand != "" {
+			backup.Hooks.Pre = append([]HookConfig{{Command: backup.PreCommand}}, backup.Hooks.Pre...)
+		}
+		if backup.PostCommand != "" {
+			backup.Hooks.PostSuccess = append([]HookConfig{{Command: backup.PostCommand}}, backup.Hooks.PostSuccess...)
+		}
+		config.Backups[i] = backup
+
+		if backup.Database != nil {
+			if backup.Command != "" || len(backup.Paths) > 0 || len(backup.FilesFrom) > 0 {
+				return config, fmt.Errorf("backup %s can not have a database and a command or paths", backup.Name)
+			}
+			if err := backup.Database.validate(); err != nil {
+				return config, fmt.Errorf("invalid database of backup %s: %w", backup.Name, err)
+			}
+		} else if backup.Command != "" {
+			if len(backup.Paths) > 0 || len(backup.FilesFrom) > 0 {
+				return config, fmt.Errorf("backup %s can not have a command and paths", backup.Name)
+			}
+			if backup.StdinFilename == "" {
+				config.Backups[i].StdinFilename = backup.Name
+			}
+		} else if len(backup.Paths) == 0 && len(backup.FilesFrom) == 0 {
 			return config, fmt.Errorf("backup path is required")
 		}
 
+		if backup.Repository == "" {
+			config.Backups[i].Repository = config.Repositories[0].Name
+		} else if !repositories[backup.Repository] {
+			return config, fmt.Errorf("unknown repository %s of backup %s", backup.Repository, backup.Name)
+		}
+
 		if names[backup.Name] {
 			return config, fmt.Errorf("duplicate backup name: %s", backup.Name)
 		}
 		names[backup.Name] = true
 
-		if backup.ExcludeFile != "" {
-			_, err := os.Stat(backup.ExcludeFile)
+		for _, file := range slices.Concat(backup.ExcludeFiles, backup.FilesFrom) {
+			_, err := os.Stat(file)
+			if os.IsNotExist(err) {
+				return config, fmt.Errorf("file of backup %s does not exist: %s", backup.Name, file)
+			}
+		}
+
+		if backup.Retention != nil && backup.Retention.IsEmpty() {
+			return config, fmt.Errorf("retention policy of backup %s must keep at least one snapshot", backup.Name)
+		}
+
+		// Fall back to global schedules
+		if backup.Cron == "" {
+			config.Backups[i].Cron = config.Cron.Backup
+		}
+		if backup.S3Cron == "" {
+			config.Backups[i].S3Cron = config.Cron.S3
+		}
+
+		hooks := slices.Concat(backup.Hooks.Pre, backup.Hooks.PostSuccess, backup.Hooks.PostFailure, backup.Hooks.Finally)
+		if err := validateHooks(hooks); err != nil {
+			return config, fmt.Errorf("invalid hooks of backup %s: %w", backup.Name, err)
+		}
+
+		containers := slices.Concat(backup.StopContainers, backup.PauseContainers)
+		if slices.Contains(containers, "") {
+			return config, fmt.Errorf("backup %s has an empty container name", backup.Name)
+		}
+		for _, container := range backup.PauseContainers {
+			if slices.Contains(backup.StopContainers, container) {
+				return config, fmt.Errorf("container %s of backup %s can not be stopped and paused", container, backup.Name)
+			}
+		}
+
+		for _, path := range backup.Paths {
+			if path == "" {
+				return config, fmt.Errorf("backup %s has an empty path", backup.Name)
+			}
+			_, err := os.Stat(path)
 			if os.IsNotExist(err) {
-				return config, fmt.Errorf("exclude file does not exist: %s", backup.ExcludeFile)
+				slog.Warn("backup path does not exist yet", "backup", backup.Name, "path", path)
+			}
+		}
+	}
+
+	// Validate notification configurations
+	for i, notification := range config.Notifications {
+		if notification.Name == "" {
+			config.Notifications[i].Name = notification.Type
+		}
+
+		switch notification.Type {
+		case "webhook", "slack", "ntfy", "gotify":
+			if notification.URL == "" {
+				return config, fmt.Errorf("notification url is required for type %s", notification.Type)
+			}
+		case "email":
+			if notification.SMTP.Host == "" || notification.SMTP.From == "" || len(notification.SMTP.To) == 0 {
+				return config, fmt.Errorf("notification smtp host, from and to are required for type email")
+			}
+			if notification.SMTP.Port == 0 {
+				config.Notifications[i].SMTP.Port = 587
+			}
+		default:
+			return config, fmt.Errorf("invalid notification type: %s", notification.Type)
+		}
+
+		for _, job := range notification.Jobs {
+			switch job {
+			case "backup", "s3", "check", "prune":
+			default:
+				return config, fmt.Errorf("invalid notification job: %s", job)
+			}
+		}
+
+		if len(notification.Events) == 0 {
+			config.Notifications[i].Events = []string{"failed"}
+		}
+		for _, event := range notification.Events {
+			switch event {
+			case "started", "succeeded", "failed":
+			default:
+				return config, fmt.Errorf("invalid notification event: %s", event)
 			}
 		}
 
-		_, err := os.Stat(backup.Path)
-		if os.IsNotExist(err) {
-			slog.Warn("backup path does not exist yet", "path", backup.Path)
+		switch notification.Severity {
+		case "":
+			config.Notifications[i].Severity = "info"
+		case "info", "warning", "error":
+		default:
+			return config, fmt.Errorf("invalid notification severity: %s", notification.Severity)
 		}
 	}
 
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRepositoryConfigValidate(t *testing.T) {
+	t.Setenv("REPO_PASSWORD", "from-env")
+	t.Setenv("REST_PASSWORD", "rest-secret")
+	t.Setenv("S3_ACCESS_KEY", "access")
+	t.Setenv("S3_SECRET_KEY", "secret")
+
+	tests := []struct {
+		name       string
+		config     RepositoryConfig
+		repository string
+		password   string
+		env        []string
+		err        string
+	}{
+		{
+			name:       "url",
+			config:     RepositoryConfig{Repository: "/data/restic", Password: "x"},
+			repository: "/data/restic",
+			password:   "x",
+			env:        []string{},
+		},
+		{
+			name:       "password env",
+			config:     RepositoryConfig{Repository: "/data/restic", PasswordEnv: "REPO_PASSWORD"},
+			repository: "/data/restic",
+			password:   "from-env",
+			env:        []string{},
+		},
+		{
+			name:       "rest",
+			config:     RepositoryConfig{Password: "x", Rest: &RestBackendConfig{URL: "https://backup.example.com/repo", Username: "user", PasswordEnv: "REST_PASSWORD"}},
+			repository: "rest:https://backup.example.com/repo",
+			password:   "x",
+			env:        []string{"RESTIC_REST_USERNAME=user", "RESTIC_REST_PASSWORD=rest-secret"},
+		},
+		{
+			name:       "sftp without port",
+			config:     RepositoryConfig{Password: "x", SFTP: &SFTPBackendConfig{Host: "backup.example.com", User: "restic", Path: "/srv/restic"}},
+			repository: "sftp:restic@backup.example.com:/srv/restic",
+			password:   "x",
+			env:        []string{},
+		},
+		{
+			name:       "sftp with port",
+			config:     RepositoryConfig{Password: "x", SFTP: &SFTPBackendConfig{Host: "backup.example.com", Port: 2222, User: "restic", Path: "/srv/restic"}},
+			repository: "sftp://restic@backup.example.com:2222//srv/restic",
+			password:   "x",
+			env:        []string{},
+		},
+		{
+			name:       "s3 default endpoint",
+			config:     RepositoryConfig{PasswordFile: "/run/secrets/restic", S3: &S3BackendConfig{Bucket: "backups", AccessKey: "a", SecretKey: "b"}},
+			repository: "s3:s3.amazonaws.com/backups",
+			env:        []string{"AWS_ACCESS_KEY_ID=a", "AWS_SECRET_ACCESS_KEY=b"},
+		},
+		{
+			name:       "s3 endpoint and path",
+			config:     RepositoryConfig{PasswordCommand: "pass restic", S3: &S3BackendConfig{Endpoint: "http://minio:9000/", Bucket: "backups", Path: "/host/", Region: "eu-central-1", AccessKeyEnv: "S3_ACCESS_KEY", SecretKeyEnv: "S3_SECRET_KEY"}},
+			repository: "s3:http://minio:9000/backups/host",
+			env:        []string{"AWS_ACCESS_KEY_ID=access", "AWS_SECRET_ACCESS_KEY=secret", "AWS_DEFAULT_REGION=eu-central-1"},
+		},
+		{
+			name:   "missing url",
+			config: RepositoryConfig{Password: "x"},
+			err:    "repository url is required",
+		},
+		{
+			name:   "rest username without password",
+			config: RepositoryConfig{Password: "x", Rest: &RestBackendConfig{URL: "https://backup.example.com", Username: "user"}},
+			err:    "rest password is required",
+		},
+		{
+			name:   "sftp without path",
+			config: RepositoryConfig{Password: "x", SFTP: &SFTPBackendConfig{Host: "backup.example.com"}},
+			err:    "sftp host and path are required",
+		},
+		{
+			name:   "s3 without keys",
+			config: RepositoryConfig{Password: "x", S3: &S3BackendConfig{Bucket: "backups"}},
+			err:    "s3 access key and secret key are required",
+		},
+		{
+			name:   "several backends",
+			config: RepositoryConfig{Password: "x", Rest: &RestBackendConfig{URL: "https://backup.example.com"}, SFTP: &SFTPBackendConfig{Host: "backup.example.com", Path: "restic"}},
+			err:    "only one of rest, sftp and s3",
+		},
+		{
+			name:   "no password",
+			config: RepositoryConfig{Repository: "/data/restic"},
+			err:    "exactly one of password",
+		},
+		{
+			name:   "several passwords",
+			config: RepositoryConfig{Repository: "/data/restic", Password: "x", PasswordFile: "/run/secrets/restic"},
+			err:    "exactly one of password",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.config
+			err := r.validate()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if r.Repository != tt.repository {
+				t.Errorf("repository = %q, want %q", r.Repository, tt.repository)
+			}
+			if r.Password != tt.password {
+				t.Errorf("password = %q, want %q", r.Password, tt.password)
+			}
+			if env := r.Env(); !slices.Equal(env, tt.env) {
+				t.Errorf("env = %q, want %q", env, tt.env)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+)
+
+// Dump is a command that writes a database dump to stdout
+type Dump struct {
+	Command  []string
+	Env      []string
+	Filename string
+}
+
+// NewDump creates the dump command of a database, cleanup removes temporary files and must be called
+// after the dump finished
+func NewDump(name string, c config.DatabaseConfig) (dump Dump, cleanup func(), err error) {
+	cleanup = func() {}
+	password := ""
+	if c.PasswordEnv != "" {
+		password = os.Getenv(c.PasswordEnv)
+	}
+	port := strconv.Itoa(c.Port)
+
+	switch c.Type {
+	case config.DatabaseTypePostgres:
+		dump.Filename = name + ".sql"
+		if c.Database != "" {
+			dump.Command = []string{"pg_dump", "--host", c.Host, "--port", port, "--username", c.User}
+			dump.Command = append(append(dump.Command, c.Options...), c.Database)
+		} else {
+			dump.Command = []string{"pg_dumpall", "--host", c.Host, "--port", port, "--username", c.User}
+			dump.Command = append(dump.Command, c.Options...)
+		}
+		if password != "" {
+			dump.Env = append(dump.Env, "PGPASSWORD="+password)
+		}
+	case config.DatabaseTypeMySQL:
+		dump.Filename = name + ".sql"
+		dump.Command = []string{"mysqldump", "--host", c.Host, "--port", port, "--user", c.User, "--single-transaction"}
+		dump.Command = append(dump.Command, c.Options...)
+		if c.Database != "" {
+			dump.Command = append(dump.Command, "--databases", c.Database)
+		} else {
+			dump.Command = append(dump.Command, "--all-databases")
+		}
+		if password != "" {
+			dump.Env = append(dump.Env, "MYSQL_PWD="+password)
+		}
+	case config.DatabaseTypeMongoDB:
+		dump.Filename = name + ".archive"
+		dump.Command = []string{"mongodump", "--archive", "--host", c.Host, "--port", port}
+		if c.User != "" {
+			dump.Command = append(dump.Command, "--username", c.User)
+		}
+		if c.Database != "" {
+			dump.Command = append(dump.Command, "--db", c.Database)
+		}
+		// mongodump only reads the password from the command line or a config file,
+		// use a config file so it does not show up in the process list
+		if password != "" {
+			file, err := writeMongoConfig(password)
+			if err != nil {
+				return Dump{}, cleanup, err
+			}
+			cleanup = func() { os.Remove(file) }
+			dump.Command = append(dump.Command, "--config", file)
+		}
+		dump.Command = append(dump.Command, c.Options...)
+	case config.DatabaseTypeSQLite:
+		dump.Filename = name + ".sqlite"
+		// The online backup needs a seekable file, so it is written to a temporary file first
+		script := `tmp=$(mktemp) && sqlite3 "$1" ".backup '$tmp'" && cat "$tmp"; status=$?; rm -f "$tmp"; exit $status`
+		dump.Command = []string{"sh", "-c", script, "sh", c.Path}
+	case config.DatabaseTypeRedis:
+		dump.Filename = name + ".rdb"
+		dump.Command = []string{"redis-cli", "-h", c.Host, "-p", port}
+		if c.User != "" {
+			dump.Command = append(dump.Command, "--user", c.User)
+		}
+		dump.Command = append(append(dump.Command, c.Options...), "--rdb", "-")
+		if password != "" {
+			dump.Env = append(dump.Env, "REDISCLI_AUTH="+password)
+		}
+	default:
+		return Dump{}, cleanup, fmt.Errorf("unsupported database type: %s", c.Type)
+	}
+
+	return dump, cleanup, nil
+}
+
+func writeMongoConfig(password string) (string, error) {
+	file, err := os.CreateTemp("", "mongodump-*.yaml")
+	if err != nil {
+		return "", fmt.Errorf("failed to create mongodump config: %w", err)
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "password: %s\n", strconv.Quote(password))
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write mongodump config: %w", err)
+	}
+
+	return file.Name(), nil
+}
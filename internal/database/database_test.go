@@ -0,0 +1,132 @@
+package database
+
+import (
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+)
+
+func TestNewDump(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "secret")
+
+	tests := []struct {
+		name     string
+		config   config.DatabaseConfig
+		command  []string
+		env      []string
+		filename string
+	}{
+		{
+			name:     "postgres database",
+			config:   config.DatabaseConfig{Type: config.DatabaseTypePostgres, Host: "db", Port: 5432, User: "postgres", PasswordEnv: "DB_PASSWORD", Database: "app", Options: []string{"--no-owner"}},
+			command:  []string{"pg_dump", "--host", "db", "--port", "5432", "--username", "postgres", "--no-owner", "app"},
+			env:      []string{"PGPASSWORD=secret"},
+			filename: "app-db.sql",
+		},
+		{
+			name:     "postgres all databases",
+			config:   config.DatabaseConfig{Type: config.DatabaseTypePostgres, Host: "db", Port: 5432, User: "postgres"},
+			command:  []string{"pg_dumpall", "--host", "db", "--port", "5432", "--username", "postgres"},
+			filename: "app-db.sql",
+		},
+		{
+			name:     "mysql database",
+			config:   config.DatabaseConfig{Type: config.DatabaseTypeMySQL, Host: "db", Port: 3306, User: "root", PasswordEnv: "DB_PASSWORD", Database: "app"},
+			command:  []string{"mysqldump", "--host", "db", "--port", "3306", "--user", "root", "--single-transaction", "--databases", "app"},
+			env:      []string{"MYSQL_PWD=secret"},
+			filename: "app-db.sql",
+		},
+		{
+			name:     "mysql all databases",
+			config:   config.DatabaseConfig{Type: config.DatabaseTypeMySQL, Host: "db", Port: 3306, User: "root"},
+			command:  []string{"mysqldump", "--host", "db", "--port", "3306", "--user", "root", "--single-transaction", "--all-databases"},
+			filename: "app-db.sql",
+		},
+		{
+			name:     "mongodb without password",
+			config:   config.DatabaseConfig{Type: config.DatabaseTypeMongoDB, Host: "db", Port: 27017, User: "admin", Database: "app", Options: []string{"--gzip"}},
+			command:  []string{"mongodump", "--archive", "--host", "db", "--port", "27017", "--username", "admin", "--db", "app", "--gzip"},
+			filename: "app-db.archive",
+		},
+		{
+			name:     "sqlite",
+			config:   config.DatabaseConfig{Type: config.DatabaseTypeSQLite, Path: "/data/app.db"},
+			filename: "app-db.sqlite",
+		},
+		{
+			name:     "redis",
+			config:   config.DatabaseConfig{Type: config.DatabaseTypeRedis, Host: "cache", Port: 6379, User: "default", PasswordEnv: "DB_PASSWORD"},
+			command:  []string{"redis-cli", "-h", "cache", "-p", "6379", "--user", "default", "--rdb", "-"},
+			env:      []string{"REDISCLI_AUTH=secret"},
+			filename: "app-db.rdb",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dump, cleanup, err := NewDump("app-db", tt.config)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer cleanup()
+
+			if dump.Filename != tt.filename {
+				t.Errorf("filename = %q, want %q", dump.Filename, tt.filename)
+			}
+			if !slices.Equal(dump.Env, tt.env) {
+				t.Errorf("env = %q, want %q", dump.Env, tt.env)
+			}
+			// The sqlite command is a shell script, only its database argument is checked
+			if tt.config.Type == config.DatabaseTypeSQLite {
+				if dump.Command[0] != "sh" || dump.Command[len(dump.Command)-1] != tt.config.Path {
+					t.Errorf("command = %q, want a shell script with the path %s", dump.Command, tt.config.Path)
+				}
+				return
+			}
+			if !slices.Equal(dump.Command, tt.command) {
+				t.Errorf("command = %q, want %q", dump.Command, tt.command)
+			}
+		})
+	}
+}
+
+func TestNewDumpMongoDBPassword(t *testing.T) {
+	t.Setenv("DB_PASSWORD", `se"cret`)
+
+	dump, cleanup, err := NewDump("app-db", config.DatabaseConfig{Type: config.DatabaseTypeMongoDB, Host: "db", Port: 27017, PasswordEnv: "DB_PASSWORD"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The password is passed in a config file instead of the command line
+	i := slices.Index(dump.Command, "--config")
+	if i < 0 || i == len(dump.Command)-1 {
+		t.Fatalf("command %q has no --config file", dump.Command)
+	}
+	if slices.Contains(dump.Command, `se"cret`) {
+		t.Errorf("command %q contains the password", dump.Command)
+	}
+	file := dump.Command[i+1]
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read config file: %v", err)
+	}
+	if string(content) != "password: \"se\\\"cret\"\n" {
+		t.Errorf("config file = %q", content)
+	}
+
+	cleanup()
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("config file %s was not removed by cleanup", file)
+	}
+}
+
+func TestNewDumpUnsupportedType(t *testing.T) {
+	_, cleanup, err := NewDump("app-db", config.DatabaseConfig{Type: "oracle"})
+	defer cleanup()
+	if err == nil {
+		t.Fatal("expected an error for an unsupported type")
+	}
+}
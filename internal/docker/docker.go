@@ -0,0 +1,231 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+)
+
+const labelPrefix = "auto-restic."
+
+// Client talks to the Docker Engine API on a unix socket
+type Client struct {
+	http *http.Client
+}
+
+type Container struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+	State  string            `json:"State"`
+}
+
+func (c Container) Name() string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+func New(socket string) *Client {
+	return &Client{
+		http: &http.Client{
+			Timeout: time.Minute,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, out any) error {
+	// The host is ignored, requests always go to the socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker"+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create docker request: %w", err)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send docker request: %w", err)
+	}
+	defer res.Body.Close()
+
+	// 304 is returned for containers that are already in the requested state
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusNotModified {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("docker api returned %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode docker response: %w", err)
+	}
+	return nil
+}
+
+// ListContainers lists running and stopped containers with the given label, e.g. "auto-restic.enable=true"
+func (c *Client) ListContainers(ctx context.Context, label string) ([]Container, error) {
+	filters, err := json.Marshal(map[string][]string{"label": {label}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode container filters: %w", err)
+	}
+
+	var containers []Container
+	err = c.do(ctx, http.MethodGet, "/containers/json?all=true&filters="+url.QueryEscape(string(filters)), &containers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	return containers, nil
+}
+
+func (c *Client) InspectContainer(ctx context.Context, name string) (Container, error) {
+	var inspect struct {
+		ID     string `json:"Id"`
+		Name   string `json:"Name"`
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+		State struct {
+			Status string `json:"Status"`
+		} `json:"State"`
+	}
+	err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/json", &inspect)
+	if err != nil {
+		return Container{}, fmt.Errorf("failed to inspect container %s: %w", name, err)
+	}
+
+	return Container{
+		ID:     inspect.ID,
+		Names:  []string{inspect.Name},
+		Labels: inspect.Config.Labels,
+		State:  inspect.State.Status,
+	}, nil
+}
+
+func (c *Client) StopContainer(ctx context.Context, name string) error {
+	err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/stop", nil)
+	if err != nil {
+		return fmt.Errorf("failed to stop container %s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *Client) StartContainer(ctx context.Context, name string) error {
+	err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/start", nil)
+	if err != nil {
+		return fmt.Errorf("failed to start container %s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *Client) PauseContainer(ctx context.Context, name string) error {
+	err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/pause", nil)
+	if err != nil {
+		return fmt.Errorf("failed to pause container %s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *Client) UnpauseContainer(ctx context.Context, name string) error {
+	err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/unpause", nil)
+	if err != nil {
+		return fmt.Errorf("failed to unpause container %s: %w", name, err)
+	}
+	return nil
+}
+
+// DiscoverBackups creates backups from the labels of containers with "auto-restic.enable=true".
+// Backups with the name of a configured backup are skipped, so the config always wins.
+// Containers with invalid labels are skipped as well and returned as invalid, one error each.
+func (c *Client) DiscoverBackups(ctx context.Context, cfg config.Config) (backups []config.BackupConfig, invalid []error, err error) {
+	containers, err := c.ListContainers(ctx, labelPrefix+"enable=true")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := map[string]bool{}
+	for _, backup := range cfg.Backups {
+		names[backup.Name] = true
+	}
+
+	backups = []config.BackupConfig{}
+	for _, container := range containers {
+		backup, err := backupFromLabels(container, cfg)
+		if err != nil {
+			invalid = append(invalid, err)
+			continue
+		}
+		if names[backup.Name] {
+			continue
+		}
+		names[backup.Name] = true
+		backups = append(backups, backup)
+	}
+
+	return backups, invalid, nil
+}
+
+func backupFromLabels(container Container, cfg config.Config) (config.BackupConfig, error) {
+	label := func(key string) string {
+		return strings.TrimSpace(container.Labels[labelPrefix+key])
+	}
+	list := func(key string) []string {
+		values := []string{}
+		for _, value := range strings.Split(label(key), ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		return values
+	}
+
+	backup := config.BackupConfig{
+		Name:       label("name"),
+		Repository: label("repository"),
+		Paths:      list("path"),
+		Excludes:   list("exclude"),
+		Cron:       cfg.Cron.Backup,
+		S3Cron:     cfg.Cron.S3,
+	}
+	if command := label("pre_command"); command != "" {
+		backup.Hooks.Pre = []config.HookConfig{{Command: command}}
+	}
+	if command := label("post_command"); command != "" {
+		backup.Hooks.PostSuccess = []config.HookConfig{{Command: command}}
+	}
+	if command := label("finally_command"); command != "" {
+		backup.Hooks.Finally = []config.HookConfig{{Command: command}}
+	}
+	if backup.Name == "" {
+		backup.Name = container.Name()
+	}
+	if len(backup.Paths) == 0 {
+		return backup, fmt.Errorf("label %spath is required on container %s", labelPrefix, container.Name())
+	}
+	if backup.Repository == "" {
+		backup.Repository = cfg.Repositories[0].Name
+	} else if _, ok := cfg.GetRepository(backup.Repository); !ok {
+		return backup, fmt.Errorf("unknown repository %s on container %s", backup.Repository, container.Name())
+	}
+	if label("stop") == "true" {
+		backup.StopContainers = []string{container.Name()}
+	} else if label("pause") == "true" {
+		backup.PauseContainers = []string{container.Name()}
+	}
+
+	return backup, nil
+}
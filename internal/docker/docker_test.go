@@ -0,0 +1,205 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+)
+
+// newTestSocket serves handler as Docker API on a unix socket and returns the path of the socket
+func newTestSocket(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on socket: %v", err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return socket
+}
+
+// listHandler serves the containers like Docker, filtered by the label filter of the request
+func listHandler(t *testing.T, containers []Container) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/containers/json" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("all") != "true" {
+			t.Errorf("containers are listed without all=true")
+		}
+		var filters map[string][]string
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters); err != nil {
+			t.Errorf("invalid filters: %v", err)
+		}
+
+		matching := []Container{}
+		for _, container := range containers {
+			matches := true
+			for _, label := range filters["label"] {
+				key, value, _ := strings.Cut(label, "=")
+				if container.Labels[key] != value {
+					matches = false
+				}
+			}
+			if matches {
+				matching = append(matching, container)
+			}
+		}
+		json.NewEncoder(w).Encode(matching)
+	})
+}
+
+func TestListContainers(t *testing.T) {
+	socket := newTestSocket(t, listHandler(t, []Container{
+		{ID: "1", Names: []string{"/app"}, Labels: map[string]string{"auto-restic.enable": "true"}, State: "running"},
+		{ID: "2", Names: []string{"/other"}, Labels: map[string]string{}, State: "exited"},
+	}))
+
+	containers, err := New(socket).ListContainers(context.Background(), "auto-restic.enable=true")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(containers) != 1 || containers[0].Name() != "app" || containers[0].State != "running" {
+		t.Errorf("containers = %+v, want only app", containers)
+	}
+}
+
+func TestInspectContainer(t *testing.T) {
+	socket := newTestSocket(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/app/json":
+			w.Write([]byte(`{"Id":"1","Name":"/app","Config":{"Labels":{"auto-restic.stop":"true"}},"State":{"Status":"paused"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such container"}`))
+		}
+	}))
+	c := New(socket)
+
+	container, err := c.InspectContainer(context.Background(), "app")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if container.ID != "1" || container.Name() != "app" || container.State != "paused" || container.Labels["auto-restic.stop"] != "true" {
+		t.Errorf("container = %+v", container)
+	}
+
+	_, err = c.InspectContainer(context.Background(), "missing")
+	if err == nil || !strings.Contains(err.Error(), "No such container") {
+		t.Errorf("error = %v, want the message of the docker api", err)
+	}
+}
+
+func TestContainerActions(t *testing.T) {
+	requests := []string{}
+	socket := newTestSocket(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		// Docker answers 304 for containers already in the state
+		if strings.HasSuffix(r.URL.Path, "/start") {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	c := New(socket)
+	ctx := context.Background()
+
+	for _, action := range []func(context.Context, string) error{c.StopContainer, c.StartContainer, c.PauseContainer, c.UnpauseContainer} {
+		if err := action(ctx, "app"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	want := []string{"POST /containers/app/stop", "POST /containers/app/start", "POST /containers/app/pause", "POST /containers/app/unpause"}
+	if !slices.Equal(requests, want) {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+}
+
+func TestDiscoverBackups(t *testing.T) {
+	labels := func(l map[string]string) map[string]string {
+		l["auto-restic.enable"] = "true"
+		return l
+	}
+	socket := newTestSocket(t, listHandler(t, []Container{
+		{Names: []string{"/nextcloud"}, Labels: labels(map[string]string{
+			"auto-restic.name":        "cloud",
+			"auto-restic.path":        "/data/nextcloud/config, /data/nextcloud/data",
+			"auto-restic.exclude":     "*.tmp,,cache",
+			"auto-restic.repository":  "offsite",
+			"auto-restic.pre_command": "occ maintenance:mode --on",
+			"auto-restic.stop":        "true",
+		})},
+		{Names: []string{"/wiki"}, Labels: labels(map[string]string{
+			"auto-restic.path":  "/data/wiki",
+			"auto-restic.pause": "true",
+		})},
+		{Names: []string{"/disabled"}, Labels: map[string]string{"auto-restic.enable": "false", "auto-restic.path": "/data/disabled"}},
+		{Names: []string{"/no-path"}, Labels: labels(map[string]string{})},
+		{Names: []string{"/unknown-repo"}, Labels: labels(map[string]string{"auto-restic.path": "/data", "auto-restic.repository": "missing"})},
+		{Names: []string{"/configured"}, Labels: labels(map[string]string{"auto-restic.path": "/data/configured"})},
+	}))
+
+	cfg := config.Config{
+		Repositories: []config.RepositoryConfig{{Name: "default"}, {Name: "offsite"}},
+		Backups:      []config.BackupConfig{{Name: "configured"}},
+	}
+	cfg.Cron.Backup = "0 2 * * *"
+	cfg.Cron.S3 = "0 4 * * *"
+
+	backups, invalid, err := New(socket).DiscoverBackups(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Invalid containers are skipped, the others are still discovered
+	if len(invalid) != 2 {
+		t.Fatalf("invalid = %v, want the containers without path and with an unknown repository", invalid)
+	}
+	if !strings.Contains(invalid[0].Error(), "path is required on container no-path") || !strings.Contains(invalid[1].Error(), "unknown repository missing") {
+		t.Errorf("invalid = %v", invalid)
+	}
+
+	if len(backups) != 2 {
+		t.Fatalf("backups = %+v, want cloud and wiki", backups)
+	}
+	cloud, wiki := backups[0], backups[1]
+	if cloud.Name != "cloud" || cloud.Repository != "offsite" || cloud.Cron != "0 2 * * *" || cloud.S3Cron != "0 4 * * *" {
+		t.Errorf("cloud = %+v", cloud)
+	}
+	if !slices.Equal(cloud.Paths, []string{"/data/nextcloud/config", "/data/nextcloud/data"}) || !slices.Equal(cloud.Excludes, []string{"*.tmp", "cache"}) {
+		t.Errorf("cloud paths = %q, excludes = %q", cloud.Paths, cloud.Excludes)
+	}
+	if !slices.Equal(cloud.StopContainers, []string{"nextcloud"}) || len(cloud.PauseContainers) != 0 {
+		t.Errorf("cloud stops %q and pauses %q", cloud.StopContainers, cloud.PauseContainers)
+	}
+	if len(cloud.Hooks.Pre) != 1 || cloud.Hooks.Pre[0].Command != "occ maintenance:mode --on" {
+		t.Errorf("cloud pre hooks = %+v", cloud.Hooks.Pre)
+	}
+
+	// The name defaults to the container and the repository to the first one
+	if wiki.Name != "wiki" || wiki.Repository != "default" {
+		t.Errorf("wiki = %+v", wiki)
+	}
+	if !slices.Equal(wiki.PauseContainers, []string{"wiki"}) || len(wiki.StopContainers) != 0 {
+		t.Errorf("wiki stops %q and pauses %q", wiki.StopContainers, wiki.PauseContainers)
+	}
+}
+
+func TestDiscoverBackupsUnavailable(t *testing.T) {
+	_, _, err := New(filepath.Join(t.TempDir(), "missing.sock")).DiscoverBackups(context.Background(), config.Config{})
+	if err == nil {
+		t.Fatal("expected an error without docker socket")
+	}
+}
@@ -0,0 +1,200 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+	"github.com/korbiniankuhn/auto-restic/internal/notify"
+)
+
+type Status string
+
+const (
+	StatusSuccess Status = "success"
+	StatusFailure Status = "failure"
+)
+
+type Run struct {
+	Job           string    `json:"job"`
+	Backup        string    `json:"backup,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	Duration      float64   `json:"duration_seconds"`
+	Status        Status    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	SnapshotID    string    `json:"snapshot_id,omitempty"`
+	BytesAdded    int64     `json:"bytes_added,omitempty"`
+	BytesUploaded int64     `json:"bytes_uploaded,omitempty"`
+}
+
+type Filter struct {
+	Job    string
+	Backup string
+	Status Status
+	Since  time.Time
+	Limit  int
+}
+
+func (f Filter) matches(run Run) bool {
+	if f.Job != "" && run.Job != f.Job {
+		return false
+	}
+	if f.Backup != "" && run.Backup != f.Backup {
+		return false
+	}
+	if f.Status != "" && run.Status != f.Status {
+		return false
+	}
+	if !f.Since.IsZero() && run.StartedAt.Before(f.Since) {
+		return false
+	}
+	return true
+}
+
+// Store appends job runs to a JSON lines file
+type Store struct {
+	mu   sync.Mutex
+	path string
+}
+
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	return &Store{
+		path: filepath.Join(dir, "history.jsonl"),
+	}, nil
+}
+
+func (s *Store) Add(run Run) error {
+	line, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("failed to marshal run: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+
+	return nil
+}
+
+// List returns matching runs, newest first
+func (s *Store) List(filter Filter) ([]Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runs := []Run{}
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return runs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var run Run
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			// Skip partially written lines
+			continue
+		}
+		if filter.matches(run) {
+			runs = append(runs, run)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+
+	// Reverse to newest first
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+
+	if filter.Limit > 0 && len(runs) > filter.Limit {
+		runs = runs[:filter.Limit]
+	}
+
+	return runs, nil
+}
+
+// Notify records finished job events
+func (s *Store) Notify(ctx context.Context, e notify.Event) error {
+	if e.Type == notify.EventStarted {
+		return nil
+	}
+
+	run := Run{
+		Job:           string(e.Job),
+		Backup:        e.Backup,
+		StartedAt:     e.StartedAt,
+		FinishedAt:    e.Time,
+		Duration:      e.Time.Sub(e.StartedAt).Seconds(),
+		Status:        StatusSuccess,
+		Error:         e.Error,
+		SnapshotID:    e.SnapshotID,
+		BytesAdded:    e.BytesAdded,
+		BytesUploaded: e.BytesUploaded,
+	}
+	if e.Type == notify.EventFailed {
+		run.Status = StatusFailure
+	}
+
+	return s.Add(run)
+}
+
+// SeedMetrics restores gauges that are only set by job runs from the latest successful run per job
+// and backup: the durations, the snapshot ID and the bytes added. The file and directory counts of
+// the restic summary are not in the history and stay empty until the next backup. repositories maps
+// the backup names to their repository, runs of other backups are skipped for the restic metrics.
+func (s *Store) SeedMetrics(m *metrics.Metrics, repositories map[string]string) error {
+	runs, err := s.List(Filter{Status: StatusSuccess})
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, run := range runs {
+		key := run.Job + "/" + run.Backup
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		switch notify.Job(run.Job) {
+		case notify.JobBackup:
+			if repository, ok := repositories[run.Backup]; ok {
+				m.SetResticDurationByBackupName(repository, run.Backup, run.Duration)
+				if run.SnapshotID != "" {
+					m.SetResticSnapshotByBackupName(repository, run.Backup, run.SnapshotID, run.BytesAdded)
+				}
+			}
+		case notify.JobS3:
+			m.SetS3DurationByBackupName(run.Backup, run.Duration)
+		}
+	}
+
+	return nil
+}
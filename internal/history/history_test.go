@@ -0,0 +1,162 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+	"github.com/korbiniankuhn/auto-restic/internal/notify"
+)
+
+var start = time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)
+
+func newTestStore(t *testing.T, runs ...Run) *Store {
+	t.Helper()
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to open history: %v", err)
+	}
+	for _, run := range runs {
+		if err := s.Add(run); err != nil {
+			t.Fatalf("failed to add run: %v", err)
+		}
+	}
+	return s
+}
+
+func TestList(t *testing.T) {
+	s := newTestStore(t,
+		Run{Job: "backup", Backup: "app", StartedAt: start, Status: StatusSuccess},
+		Run{Job: "backup", Backup: "db", StartedAt: start.Add(time.Hour), Status: StatusFailure},
+		Run{Job: "s3", Backup: "app", StartedAt: start.Add(2 * time.Hour), Status: StatusSuccess},
+		Run{Job: "check", StartedAt: start.Add(3 * time.Hour), Status: StatusSuccess},
+	)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []time.Duration
+	}{
+		{name: "all newest first", filter: Filter{}, want: []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour, 0}},
+		{name: "job", filter: Filter{Job: "backup"}, want: []time.Duration{time.Hour, 0}},
+		{name: "backup", filter: Filter{Backup: "app"}, want: []time.Duration{2 * time.Hour, 0}},
+		{name: "status", filter: Filter{Status: StatusFailure}, want: []time.Duration{time.Hour}},
+		{name: "since", filter: Filter{Since: start.Add(2 * time.Hour)}, want: []time.Duration{3 * time.Hour, 2 * time.Hour}},
+		{name: "limit keeps the newest", filter: Filter{Limit: 2}, want: []time.Duration{3 * time.Hour, 2 * time.Hour}},
+		{name: "combined", filter: Filter{Job: "backup", Backup: "app", Status: StatusSuccess}, want: []time.Duration{0}},
+		{name: "no match", filter: Filter{Job: "prune"}, want: []time.Duration{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs, err := s.List(tt.filter)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(runs) != len(tt.want) {
+				t.Fatalf("got %d runs, want %d", len(runs), len(tt.want))
+			}
+			for i, run := range runs {
+				if want := start.Add(tt.want[i]); !run.StartedAt.Equal(want) {
+					t.Errorf("run %d started at %s, want %s", i, run.StartedAt, want)
+				}
+			}
+		})
+	}
+}
+
+func TestListWithoutFile(t *testing.T) {
+	runs, err := newTestStore(t).List(Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(runs) != 0 {
+		t.Errorf("got %d runs, want none", len(runs))
+	}
+}
+
+func TestNotify(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+
+	if err := s.Notify(ctx, notify.NewStartedEvent(notify.JobBackup, "app")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	succeeded := notify.NewFinishedEvent(notify.JobBackup, "app", start, nil)
+	succeeded.SnapshotID = "4f8a1c2e"
+	succeeded.BytesAdded = 1024
+	if err := s.Notify(ctx, succeeded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	failed := notify.NewFinishedEvent(notify.JobS3, "app", start, errors.New("upload failed"))
+	if err := s.Notify(ctx, failed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	runs, err := s.List(Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Started events are not recorded
+	if len(runs) != 2 {
+		t.Fatalf("got %d runs, want 2", len(runs))
+	}
+
+	run := runs[1]
+	if run.Job != "backup" || run.Backup != "app" || run.Status != StatusSuccess || run.Error != "" {
+		t.Errorf("succeeded run = %+v", run)
+	}
+	if run.SnapshotID != "4f8a1c2e" || run.BytesAdded != 1024 {
+		t.Errorf("succeeded run has snapshot %q and %d bytes added", run.SnapshotID, run.BytesAdded)
+	}
+	if !run.StartedAt.Equal(start) || !run.FinishedAt.Equal(succeeded.Time) || run.Duration != succeeded.Time.Sub(start).Seconds() {
+		t.Errorf("succeeded run has times %s - %s and duration %f", run.StartedAt, run.FinishedAt, run.Duration)
+	}
+
+	run = runs[0]
+	if run.Job != "s3" || run.Status != StatusFailure || run.Error != "upload failed" {
+		t.Errorf("failed run = %+v", run)
+	}
+}
+
+func TestSeedMetrics(t *testing.T) {
+	s := newTestStore(t,
+		Run{Job: "backup", Backup: "app", StartedAt: start, Duration: 10, Status: StatusSuccess, SnapshotID: "old", BytesAdded: 1},
+		Run{Job: "backup", Backup: "app", StartedAt: start.Add(time.Hour), Duration: 20, Status: StatusSuccess, SnapshotID: "new", BytesAdded: 2048},
+		Run{Job: "backup", Backup: "app", StartedAt: start.Add(2 * time.Hour), Duration: 30, Status: StatusFailure},
+		Run{Job: "backup", Backup: "removed", StartedAt: start, Duration: 40, Status: StatusSuccess, SnapshotID: "gone"},
+		Run{Job: "s3", Backup: "app", StartedAt: start, Duration: 50, Status: StatusSuccess},
+		Run{Job: "s3", Backup: "app", StartedAt: start.Add(time.Hour), Duration: 60, Status: StatusSuccess},
+	)
+
+	m := metrics.NewMetrics()
+	if err := s.SeedMetrics(m, map[string]string{"app": "offsite"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	m.GetMetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+
+	// Only the latest successful run counts, runs of unknown backups are skipped
+	for _, want := range []string{
+		`backup_restic_snapshot_latest_duration_seconds{backup_name="app",repository="offsite"} 20`,
+		`backup_restic_backup_data_added_bytes{backup_name="app",repository="offsite"} 2048`,
+		`backup_restic_backup_snapshot_info{backup_name="app",repository="offsite",snapshot_id="new"} 1`,
+		`backup_s3_snapshot_latest_duration_seconds{backup_name="app"} 60`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing %s", want)
+		}
+	}
+	for _, unwanted := range []string{`snapshot_id="old"`, `backup_name="removed"`} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("metrics contain %s", unwanted)
+		}
+	}
+}
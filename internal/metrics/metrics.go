@@ -15,16 +15,49 @@ const (
 	SchedulerErrorResticListSnapshots    SchedulerError = "restic_list_snapshots"
 	SchedulerErrorResticGetSnapshotStats SchedulerError = "restic_get_snapshot_stats"
 	SchedulerErrorS3ListObjects          SchedulerError = "s3_list_objects"
+	SchedulerErrorDockerDiscovery        SchedulerError = "docker_discovery"
+)
+
+type ErrorKind string
+
+const (
+	ErrorKindFailed  ErrorKind = "failed"
+	ErrorKindTimeout ErrorKind = "timeout"
+	// Job was cancelled during shutdown
+	ErrorKindCancelled ErrorKind = "cancelled"
+	// Classified restic errors
+	ErrorKindLocked           ErrorKind = "locked"
+	ErrorKindWrongPassword    ErrorKind = "wrong_password"
+	ErrorKindRepoNotFound     ErrorKind = "repo_not_found"
+	ErrorKindSourceUnreadable ErrorKind = "source_unreadable"
+	ErrorKindPartial          ErrorKind = "partial"
+	// Command or database dump of a backup failed
+	ErrorKindCommandFailed ErrorKind = "command_failed"
+	// Job hook failed with on_failure abort
+	ErrorKindHookFailed ErrorKind = "hook_failed"
 )
 
 type Metrics struct {
 	schedulerErrors               *prometheus.CounterVec
+	jobErrors                     *prometheus.CounterVec
+	jobAttempts                   *prometheus.CounterVec
+	hookRuns                      *prometheus.CounterVec
+	jobProgressRatio              *prometheus.GaugeVec
+	jobProgressBytes              *prometheus.GaugeVec
+	jobProgressFiles              *prometheus.GaugeVec
+	jobProgressRemaining          *prometheus.GaugeVec
 	resticSnapshotErrors          *prometheus.CounterVec
 	resticSnapshotLatestDuration  *prometheus.GaugeVec
 	resticSnapshotLatestSize      *prometheus.GaugeVec
 	resticSnapshotLatestTimestamp *prometheus.GaugeVec
 	resticSnapshotCount           *prometheus.GaugeVec
 	resticSnapshotTotalSize       *prometheus.GaugeVec
+	resticBackupFiles             *prometheus.GaugeVec
+	resticBackupDirs              *prometheus.GaugeVec
+	resticBackupDataAdded         *prometheus.GaugeVec
+	resticBackupDataAddedPacked   *prometheus.GaugeVec
+	resticBackupBytesProcessed    *prometheus.GaugeVec
+	resticBackupSnapshotInfo      *prometheus.GaugeVec
 	s3SnapshotErrors              *prometheus.CounterVec
 	s3SnapshotLatestDuration      *prometheus.GaugeVec
 	s3SnapshotLatestSize          *prometheus.GaugeVec
@@ -44,59 +77,176 @@ func NewMetrics() *Metrics {
 			},
 			[]string{"operation"},
 		),
+		jobErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "backup",
+				Subsystem: "job",
+				Name:      "errors_total",
+				Help:      "Total number of failed or partial job runs by job, backup name and error kind (e.g. failed, timeout, locked)",
+			},
+			[]string{"job", "backup_name", "kind"},
+		),
+		jobAttempts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "backup",
+				Subsystem: "job",
+				Name:      "attempts_total",
+				Help:      "Total number of job attempts including retries by job, backup name and result (succeeded, failed)",
+			},
+			[]string{"job", "backup_name", "result"},
+		),
+		hookRuns: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "backup",
+				Subsystem: "hook",
+				Name:      "runs_total",
+				Help:      "Total number of hook runs by job, backup name (empty for job hooks), stage and result (succeeded, failed)",
+			},
+			[]string{"job", "backup_name", "stage", "result"},
+		),
+		jobProgressRatio: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "backup",
+				Subsystem: "job",
+				Name:      "progress_ratio",
+				Help:      "Progress between 0 and 1 of running backup and S3 jobs by job and backup name",
+			},
+			[]string{"job", "backup_name"},
+		),
+		jobProgressBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "backup",
+				Subsystem: "job",
+				Name:      "progress_bytes",
+				Help:      "Bytes processed (backup) or uploaded (S3) by running jobs by job and backup name",
+			},
+			[]string{"job", "backup_name"},
+		),
+		jobProgressFiles: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "backup",
+				Subsystem: "job",
+				Name:      "progress_files",
+				Help:      "Files processed by running backup jobs by backup name",
+			},
+			[]string{"job", "backup_name"},
+		),
+		jobProgressRemaining: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "backup",
+				Subsystem: "job",
+				Name:      "progress_remaining_seconds",
+				Help:      "Estimated remaining seconds of running jobs by job and backup name",
+			},
+			[]string{"job", "backup_name"},
+		),
 		resticSnapshotErrors: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Namespace: "backup",
 				Subsystem: "restic",
 				Name:      "snapshot_errors_total",
-				Help:      "Total number of errors creating restic snapshots per backup name",
+				Help:      "Total number of errors creating restic snapshots per repository and backup name",
 			},
-			[]string{"backup_name"},
+			[]string{"repository", "backup_name"},
 		),
 		resticSnapshotLatestDuration: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: "backup",
 				Subsystem: "restic",
 				Name:      "snapshot_latest_duration_seconds",
-				Help:      "Duration in seconds of the latest restic snapshot per backup name",
+				Help:      "Duration in seconds of the latest restic snapshot per repository and backup name",
 			},
-			[]string{"backup_name"},
+			[]string{"repository", "backup_name"},
 		),
 		resticSnapshotLatestSize: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: "backup",
 				Subsystem: "restic",
 				Name:      "snapshot_latest_size_bytes",
-				Help:      "Size in bytes of the latest restic snapshot per backup name",
+				Help:      "Size in bytes of the latest restic snapshot per repository and backup name",
 			},
-			[]string{"backup_name"},
+			[]string{"repository", "backup_name"},
 		),
 		resticSnapshotLatestTimestamp: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: "backup",
 				Subsystem: "restic",
 				Name:      "snapshot_latest_timestamp_seconds",
-				Help:      "Unix timestamp of the latest restic snapshot per backup name",
+				Help:      "Unix timestamp of the latest restic snapshot per repository and backup name",
 			},
-			[]string{"backup_name"},
+			[]string{"repository", "backup_name"},
 		),
 		resticSnapshotCount: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: "backup",
 				Subsystem: "restic",
 				Name:      "snapshot_count",
-				Help:      "Total number of restic snapshots per backup name",
+				Help:      "Total number of restic snapshots per repository and backup name",
 			},
-			[]string{"backup_name"},
+			[]string{"repository", "backup_name"},
 		),
 		resticSnapshotTotalSize: prometheus.NewGaugeVec(
 			prometheus.GaugeOpts{
 				Namespace: "backup",
 				Subsystem: "restic",
 				Name:      "snapshot_total_size_bytes",
-				Help:      "Total size in bytes of all restic snapshots per backup name",
+				Help:      "Total size in bytes of all restic snapshots per repository and backup name",
 			},
-			[]string{"backup_name"},
+			[]string{"repository", "backup_name"},
+		),
+		resticBackupFiles: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "backup",
+				Subsystem: "restic",
+				Name:      "backup_files",
+				Help:      "Number of files by state (new, changed, unmodified) of the latest restic backup per repository and backup name",
+			},
+			[]string{"repository", "backup_name", "state"},
+		),
+		resticBackupDirs: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "backup",
+				Subsystem: "restic",
+				Name:      "backup_dirs",
+				Help:      "Number of directories by state (new, changed, unmodified) of the latest restic backup per repository and backup name",
+			},
+			[]string{"repository", "backup_name", "state"},
+		),
+		resticBackupDataAdded: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "backup",
+				Subsystem: "restic",
+				Name:      "backup_data_added_bytes",
+				Help:      "Bytes added to the repository by the latest restic backup per repository and backup name",
+			},
+			[]string{"repository", "backup_name"},
+		),
+		resticBackupDataAddedPacked: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "backup",
+				Subsystem: "restic",
+				Name:      "backup_data_added_packed_bytes",
+				Help:      "Compressed bytes added to the repository by the latest restic backup per repository and backup name",
+			},
+			[]string{"repository", "backup_name"},
+		),
+		resticBackupBytesProcessed: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "backup",
+				Subsystem: "restic",
+				Name:      "backup_processed_bytes",
+				Help:      "Total bytes processed by the latest restic backup per repository and backup name",
+			},
+			[]string{"repository", "backup_name"},
+		),
+		resticBackupSnapshotInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "backup",
+				Subsystem: "restic",
+				Name:      "backup_snapshot_info",
+				Help:      "Snapshot ID of the latest restic backup per repository and backup name, always 1",
+			},
+			[]string{"repository", "backup_name", "snapshot_id"},
 		),
 		s3SnapshotErrors: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -158,6 +308,7 @@ func NewMetrics() *Metrics {
 	metrics.schedulerErrors.WithLabelValues(string(SchedulerErrorResticListSnapshots)).Add(0)
 	metrics.schedulerErrors.WithLabelValues(string(SchedulerErrorResticGetSnapshotStats)).Add(0)
 	metrics.schedulerErrors.WithLabelValues(string(SchedulerErrorS3ListObjects)).Add(0)
+	metrics.schedulerErrors.WithLabelValues(string(SchedulerErrorDockerDiscovery)).Add(0)
 
 	return metrics
 }
@@ -166,19 +317,90 @@ func (m *Metrics) AddSchedulerError(operation SchedulerError) {
 	m.schedulerErrors.WithLabelValues(string(operation)).Inc()
 }
 
-func (m *Metrics) AddResticErrorByBackupName(name string) {
-	m.resticSnapshotErrors.WithLabelValues(name).Inc()
+func (m *Metrics) AddJobError(job, name string, kind ErrorKind) {
+	m.jobErrors.WithLabelValues(job, name, string(kind)).Inc()
+}
+
+func (m *Metrics) AddJobAttempt(job, name string, err error) {
+	result := "succeeded"
+	if err != nil {
+		result = "failed"
+	}
+	m.jobAttempts.WithLabelValues(job, name, result).Inc()
 }
 
-func (m *Metrics) SetResticStatsByBackupName(name string, count int, totalSize int64, latestSize int64, latestTime float64) {
-	m.resticSnapshotCount.WithLabelValues(name).Set(float64(count))
-	m.resticSnapshotTotalSize.WithLabelValues(name).Set(float64(totalSize))
-	m.resticSnapshotLatestSize.WithLabelValues(name).Set(float64(latestSize))
-	m.resticSnapshotLatestTimestamp.WithLabelValues(name).Set(latestTime)
+func (m *Metrics) AddHookRun(job, name, stage string, err error) {
+	result := "succeeded"
+	if err != nil {
+		result = "failed"
+	}
+	m.hookRuns.WithLabelValues(job, name, stage, result).Inc()
 }
 
-func (m *Metrics) SetResticDurationByBackupName(name string, duration float64) {
-	m.resticSnapshotLatestDuration.WithLabelValues(name).Set(duration)
+func (m *Metrics) SetJobProgress(job, name string, ratio float64, bytes int64, files int, remaining int) {
+	m.jobProgressRatio.WithLabelValues(job, name).Set(ratio)
+	m.jobProgressBytes.WithLabelValues(job, name).Set(float64(bytes))
+	m.jobProgressFiles.WithLabelValues(job, name).Set(float64(files))
+	m.jobProgressRemaining.WithLabelValues(job, name).Set(float64(remaining))
+}
+
+// DeleteJobProgress removes the progress of a finished job, so only running jobs are exported
+func (m *Metrics) DeleteJobProgress(job, name string) {
+	m.jobProgressRatio.DeleteLabelValues(job, name)
+	m.jobProgressBytes.DeleteLabelValues(job, name)
+	m.jobProgressFiles.DeleteLabelValues(job, name)
+	m.jobProgressRemaining.DeleteLabelValues(job, name)
+}
+
+func (m *Metrics) AddResticErrorByBackupName(repository, name string) {
+	m.resticSnapshotErrors.WithLabelValues(repository, name).Inc()
+}
+
+func (m *Metrics) SetResticStatsByBackupName(repository, name string, count int, totalSize int64, latestSize int64, latestTime float64) {
+	m.resticSnapshotCount.WithLabelValues(repository, name).Set(float64(count))
+	m.resticSnapshotTotalSize.WithLabelValues(repository, name).Set(float64(totalSize))
+	m.resticSnapshotLatestSize.WithLabelValues(repository, name).Set(float64(latestSize))
+	m.resticSnapshotLatestTimestamp.WithLabelValues(repository, name).Set(latestTime)
+}
+
+func (m *Metrics) SetResticDurationByBackupName(repository, name string, duration float64) {
+	m.resticSnapshotLatestDuration.WithLabelValues(repository, name).Set(duration)
+}
+
+// BackupSummary holds the numbers of a restic backup summary that are exported
+type BackupSummary struct {
+	SnapshotID          string
+	FilesNew            int
+	FilesChanged        int
+	FilesUnmodified     int
+	DirsNew             int
+	DirsChanged         int
+	DirsUnmodified      int
+	DataAdded           int
+	DataAddedPacked     int
+	TotalBytesProcessed int
+}
+
+func (m *Metrics) SetResticSummaryByBackupName(repository, name string, summary BackupSummary) {
+	m.resticBackupFiles.WithLabelValues(repository, name, "new").Set(float64(summary.FilesNew))
+	m.resticBackupFiles.WithLabelValues(repository, name, "changed").Set(float64(summary.FilesChanged))
+	m.resticBackupFiles.WithLabelValues(repository, name, "unmodified").Set(float64(summary.FilesUnmodified))
+	m.resticBackupDirs.WithLabelValues(repository, name, "new").Set(float64(summary.DirsNew))
+	m.resticBackupDirs.WithLabelValues(repository, name, "changed").Set(float64(summary.DirsChanged))
+	m.resticBackupDirs.WithLabelValues(repository, name, "unmodified").Set(float64(summary.DirsUnmodified))
+	m.resticBackupDataAddedPacked.WithLabelValues(repository, name).Set(float64(summary.DataAddedPacked))
+	m.resticBackupBytesProcessed.WithLabelValues(repository, name).Set(float64(summary.TotalBytesProcessed))
+	m.SetResticSnapshotByBackupName(repository, name, summary.SnapshotID, int64(summary.DataAdded))
+}
+
+// SetResticSnapshotByBackupName sets the snapshot ID and the bytes added of the latest backup, the
+// part of the summary that is kept in the history
+func (m *Metrics) SetResticSnapshotByBackupName(repository, name, snapshotID string, dataAdded int64) {
+	m.resticBackupDataAdded.WithLabelValues(repository, name).Set(float64(dataAdded))
+
+	// Only keep the latest snapshot ID
+	m.resticBackupSnapshotInfo.DeletePartialMatch(prometheus.Labels{"repository": repository, "backup_name": name})
+	m.resticBackupSnapshotInfo.WithLabelValues(repository, name, snapshotID).Set(1)
 }
 
 func (m *Metrics) AddS3ErrorByBackupName(name string) {
@@ -200,12 +422,25 @@ func (m *Metrics) GetMetricsHandler() http.Handler {
 	var r = prometheus.NewRegistry()
 	r.MustRegister(
 		m.schedulerErrors,
+		m.jobErrors,
+		m.jobAttempts,
+		m.hookRuns,
+		m.jobProgressRatio,
+		m.jobProgressBytes,
+		m.jobProgressFiles,
+		m.jobProgressRemaining,
 		m.resticSnapshotErrors,
 		m.resticSnapshotCount,
 		m.resticSnapshotTotalSize,
 		m.resticSnapshotLatestSize,
 		m.resticSnapshotLatestTimestamp,
 		m.resticSnapshotLatestDuration,
+		m.resticBackupFiles,
+		m.resticBackupDirs,
+		m.resticBackupDataAdded,
+		m.resticBackupDataAddedPacked,
+		m.resticBackupBytesProcessed,
+		m.resticBackupSnapshotInfo,
 		m.s3SnapshotErrors,
 		m.s3SnapshotCount,
 		m.s3SnapshotTotalSize,
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type EmailNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (n EmailNotifier) Notify(ctx context.Context, e Event) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	body := e.Message
+	if e.Error != "" {
+		body += "\n\n" + e.Error
+	}
+
+	msg := strings.Join([]string{
+		"From: " + n.From,
+		"To: " + strings.Join(n.To, ", "),
+		fmt.Sprintf("Subject: [auto-restic] [%s] %s", e.Severity, e.Title()),
+		"Date: " + e.Time.Format(time.RFC1123Z),
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	// net/smtp has no context support, run it in the background and give up on timeout
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(net.JoinHostPort(n.Host, strconv.Itoa(n.Port)), auth, n.From, n.To, []byte(msg))
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("failed to send email: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to send email: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+func post(ctx context.Context, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		output, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("unexpected status code %d: %s", res.StatusCode, output)
+	}
+
+	return nil
+}
+
+func postJSON(ctx context.Context, url string, headers map[string]string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	h := map[string]string{"Content-Type": "application/json"}
+	for key, value := range headers {
+		h[key] = value
+	}
+
+	return post(ctx, url, h, body)
+}
+
+type WebhookNotifier struct {
+	URL     string
+	Headers map[string]string
+}
+
+func (n WebhookNotifier) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, n.URL, n.Headers, e)
+}
+
+type SlackNotifier struct {
+	URL string
+}
+
+func (n SlackNotifier) Notify(ctx context.Context, e Event) error {
+	text := fmt.Sprintf("*[%s] %s*", e.Severity, e.Title())
+	if e.Error != "" {
+		text += fmt.Sprintf("\n```%s```", e.Error)
+	}
+
+	return postJSON(ctx, n.URL, nil, map[string]string{"text": text})
+}
+
+type NtfyNotifier struct {
+	URL   string
+	Token string
+}
+
+func (n NtfyNotifier) Notify(ctx context.Context, e Event) error {
+	priority := map[Severity]string{
+		SeverityInfo:    "default",
+		SeverityWarning: "high",
+		SeverityError:   "urgent",
+	}
+
+	headers := map[string]string{
+		"Title":    e.Title(),
+		"Priority": priority[e.Severity],
+		"Tags":     fmt.Sprintf("%s,%s", e.Job, e.Type),
+	}
+	if n.Token != "" {
+		headers["Authorization"] = "Bearer " + n.Token
+	}
+
+	body := e.Message
+	if e.Error != "" {
+		body = e.Error
+	}
+
+	return post(ctx, n.URL, headers, []byte(body))
+}
+
+type GotifyNotifier struct {
+	URL   string
+	Token string
+}
+
+func (n GotifyNotifier) Notify(ctx context.Context, e Event) error {
+	priority := map[Severity]int{
+		SeverityInfo:    2,
+		SeverityWarning: 5,
+		SeverityError:   8,
+	}
+
+	message := e.Message
+	if e.Error != "" {
+		message = e.Error
+	}
+
+	return postJSON(ctx, n.URL+"/message", map[string]string{"X-Gotify-Key": n.Token}, map[string]any{
+		"title":    e.Title(),
+		"message":  message,
+		"priority": priority[e.Severity],
+	})
+}
@@ -0,0 +1,283 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+)
+
+type Job string
+
+const (
+	JobBackup Job = "backup"
+	JobS3     Job = "s3"
+	JobCheck  Job = "check"
+	JobPrune  Job = "prune"
+)
+
+type EventType string
+
+const (
+	EventStarted   EventType = "started"
+	EventSucceeded EventType = "succeeded"
+	EventFailed    EventType = "failed"
+)
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func ParseSeverity(s string) (Severity, error) {
+	switch s {
+	case "info":
+		return SeverityInfo, nil
+	case "warning":
+		return SeverityWarning, nil
+	case "error":
+		return SeverityError, nil
+	default:
+		return SeverityInfo, fmt.Errorf("invalid severity: %s", s)
+	}
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	severity, err := ParseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*s = severity
+	return nil
+}
+
+type Event struct {
+	Type          EventType `json:"type"`
+	Job           Job       `json:"job"`
+	Backup        string    `json:"backup,omitempty"`
+	Severity      Severity  `json:"severity"`
+	Message       string    `json:"message"`
+	Error         string    `json:"error,omitempty"`
+	Time          time.Time `json:"time"`
+	StartedAt     time.Time `json:"started_at"`
+	SnapshotID    string    `json:"snapshot_id,omitempty"`
+	BytesAdded    int64     `json:"bytes_added,omitempty"`
+	BytesUploaded int64     `json:"bytes_uploaded,omitempty"`
+}
+
+func (e Event) Title() string {
+	if e.Backup != "" {
+		return fmt.Sprintf("%s %s %s", e.Job, e.Backup, e.Type)
+	}
+	return fmt.Sprintf("%s %s", e.Job, e.Type)
+}
+
+func NewStartedEvent(job Job, backup string) Event {
+	e := Event{
+		Type:     EventStarted,
+		Job:      job,
+		Backup:   backup,
+		Severity: SeverityInfo,
+		Time:     time.Now(),
+	}
+	e.Message = e.Title()
+	return e
+}
+
+func NewFinishedEvent(job Job, backup string, startedAt time.Time, err error) Event {
+	e := Event{
+		Type:      EventSucceeded,
+		Job:       job,
+		Backup:    backup,
+		Severity:  SeverityInfo,
+		Time:      time.Now(),
+		StartedAt: startedAt,
+	}
+	if err != nil {
+		e.Type = EventFailed
+		e.Severity = SeverityError
+		e.Error = err.Error()
+	}
+	e.Message = e.Title()
+	return e
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+type Filter struct {
+	Jobs        []Job
+	Events      []EventType
+	MinSeverity Severity
+}
+
+func (f Filter) Matches(e Event) bool {
+	if len(f.Jobs) > 0 && !slices.Contains(f.Jobs, e.Job) {
+		return false
+	}
+	if len(f.Events) > 0 && !slices.Contains(f.Events, e.Type) {
+		return false
+	}
+	return e.Severity >= f.MinSeverity
+}
+
+// Events queued per notifier, further events are dropped while a notifier is this far behind
+const queueSize = 100
+
+type subscription struct {
+	name     string
+	filter   Filter
+	notifier Notifier
+	events   chan Event
+}
+
+// Dispatcher delivers events in the background, so slow notifiers do not hold up jobs.
+// Every notifier has its own queue and gets the events in the order they were published.
+type Dispatcher struct {
+	mu            sync.RWMutex
+	subscriptions []subscription
+	timeout       time.Duration
+	closed        bool
+	wg            sync.WaitGroup
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		timeout: 30 * time.Second,
+	}
+}
+
+func (d *Dispatcher) Subscribe(name string, filter Filter, notifier Notifier) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s := subscription{
+		name:     name,
+		filter:   filter,
+		notifier: notifier,
+		events:   make(chan Event, queueSize),
+	}
+	d.subscriptions = append(d.subscriptions, s)
+
+	d.wg.Add(1)
+	go d.deliver(s)
+}
+
+func (d *Dispatcher) deliver(s subscription) {
+	defer d.wg.Done()
+
+	for e := range s.events {
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		err := s.notifier.Notify(ctx, e)
+		cancel()
+
+		if err != nil {
+			slog.Error("failed to send notification", "notifier", s.name, "event", e.Title(), "error", err)
+		}
+	}
+}
+
+// Publish queues the event for every matching notifier and returns immediately
+func (d *Dispatcher) Publish(e Event) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return
+	}
+
+	for _, s := range d.subscriptions {
+		if !s.filter.Matches(e) {
+			continue
+		}
+
+		select {
+		case s.events <- e:
+		default:
+			slog.Error("notification queue is full, dropping event", "notifier", s.name, "event", e.Title())
+		}
+	}
+}
+
+// Close stops accepting events and waits until the queued events are delivered
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, s := range d.subscriptions {
+			close(s.events)
+		}
+	}
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
+
+func New(configs []config.NotificationConfig) (*Dispatcher, error) {
+	d := NewDispatcher()
+
+	for _, c := range configs {
+		var notifier Notifier
+		switch c.Type {
+		case "webhook":
+			notifier = WebhookNotifier{URL: c.URL, Headers: c.Headers}
+		case "slack":
+			notifier = SlackNotifier{URL: c.URL}
+		case "ntfy":
+			notifier = NtfyNotifier{URL: c.URL, Token: c.Token}
+		case "gotify":
+			notifier = GotifyNotifier{URL: c.URL, Token: c.Token}
+		case "email":
+			notifier = EmailNotifier{
+				Host:     c.SMTP.Host,
+				Port:     c.SMTP.Port,
+				Username: c.SMTP.Username,
+				Password: c.SMTP.Password,
+				From:     c.SMTP.From,
+				To:       c.SMTP.To,
+			}
+		default:
+			return nil, fmt.Errorf("invalid notification type: %s", c.Type)
+		}
+
+		severity, err := ParseSeverity(c.Severity)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notification %s: %w", c.Name, err)
+		}
+
+		filter := Filter{MinSeverity: severity}
+		for _, job := range c.Jobs {
+			filter.Jobs = append(filter.Jobs, Job(job))
+		}
+		for _, event := range c.Events {
+			filter.Events = append(filter.Events, EventType(event))
+		}
+
+		d.Subscribe(c.Name, filter, notifier)
+	}
+
+	return d, nil
+}
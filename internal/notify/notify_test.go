@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookStub records the events posted to it, every request blocks until release is closed
+type webhookStub struct {
+	mu      sync.Mutex
+	events  []Event
+	release chan struct{}
+}
+
+func newWebhookStub(t *testing.T) (*webhookStub, *httptest.Server) {
+	stub := &webhookStub{release: make(chan struct{})}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stub.release
+
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		stub.mu.Lock()
+		stub.events = append(stub.events, e)
+		stub.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return stub, server
+}
+
+func TestPublishDoesNotWaitForNotifiers(t *testing.T) {
+	stub, server := newWebhookStub(t)
+
+	d := NewDispatcher()
+	d.Subscribe("webhook", Filter{}, WebhookNotifier{URL: server.URL})
+
+	// The stub blocks every request, so a synchronous publish would hang here
+	done := make(chan struct{})
+	go func() {
+		d.Publish(NewStartedEvent(JobBackup, "app"))
+		d.Publish(NewFinishedEvent(JobBackup, "app", time.Now(), errors.New("boom")))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on a slow notifier")
+	}
+
+	close(stub.release)
+	d.Close()
+
+	if len(stub.events) != 2 {
+		t.Fatalf("expected 2 delivered events, got %d", len(stub.events))
+	}
+	if stub.events[0].Type != EventStarted || stub.events[1].Type != EventFailed {
+		t.Errorf("events delivered out of order: %s, %s", stub.events[0].Type, stub.events[1].Type)
+	}
+	if stub.events[1].Error != "boom" || stub.events[1].Severity != SeverityError {
+		t.Errorf("unexpected failed event: %+v", stub.events[1])
+	}
+}
+
+func TestPublishFiltersEvents(t *testing.T) {
+	stub, server := newWebhookStub(t)
+	close(stub.release)
+
+	d := NewDispatcher()
+	d.Subscribe("webhook", Filter{Jobs: []Job{JobBackup}, MinSeverity: SeverityError}, WebhookNotifier{URL: server.URL})
+
+	d.Publish(NewFinishedEvent(JobBackup, "app", time.Now(), nil))
+	d.Publish(NewFinishedEvent(JobS3, "app", time.Now(), errors.New("upload failed")))
+	d.Publish(NewFinishedEvent(JobBackup, "app", time.Now(), errors.New("snapshot failed")))
+	d.Close()
+
+	if len(stub.events) != 1 || stub.events[0].Error != "snapshot failed" {
+		t.Fatalf("expected only the failed backup event, got %+v", stub.events)
+	}
+}
+
+func TestPublishAfterClose(t *testing.T) {
+	stub, server := newWebhookStub(t)
+	close(stub.release)
+
+	d := NewDispatcher()
+	d.Subscribe("webhook", Filter{}, WebhookNotifier{URL: server.URL})
+	d.Close()
+
+	d.Publish(NewStartedEvent(JobCheck, ""))
+	d.Close()
+
+	if len(stub.events) != 0 {
+		t.Fatalf("expected no events after close, got %d", len(stub.events))
+	}
+}
@@ -0,0 +1,58 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+)
+
+// Pings never delay a job by more than the timeout, a variable so tests can shorten it
+var timeout = 10 * time.Second
+
+// Start signals the beginning of a job
+func Start(p config.PingConfig) {
+	send(p.Start, "")
+}
+
+// Finish signals success or failure of a job, the error is sent as body
+func Finish(p config.PingConfig, err error) {
+	if err != nil {
+		send(p.Fail, err.Error())
+		return
+	}
+	send(p.Success, "")
+}
+
+func send(url, body string) {
+	if url == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		slog.Error("failed to create ping request", "url", url, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		slog.Error("failed to send ping", "url", url, "error", err)
+		return
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		slog.Error("failed to send ping", "url", url, "error", fmt.Errorf("unexpected status code %d", res.StatusCode))
+	}
+}
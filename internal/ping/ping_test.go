@@ -0,0 +1,126 @@
+package ping
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+)
+
+type request struct {
+	method      string
+	path        string
+	contentType string
+	body        string
+}
+
+// newTestServer records the requests and responds with status
+func newTestServer(t *testing.T, status int) (*httptest.Server, func() []request) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := []request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, request{r.Method, r.URL.Path, r.Header.Get("Content-Type"), string(body)})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []request {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(requests)
+	}
+}
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		send   func(p config.PingConfig)
+		want   []request
+	}{
+		{
+			name:   "start",
+			status: http.StatusOK,
+			send:   Start,
+			want:   []request{{http.MethodPost, "/start", "text/plain; charset=utf-8", ""}},
+		},
+		{
+			name:   "success",
+			status: http.StatusOK,
+			send:   func(p config.PingConfig) { Finish(p, nil) },
+			want:   []request{{http.MethodPost, "/success", "text/plain; charset=utf-8", ""}},
+		},
+		{
+			name:   "fail",
+			status: http.StatusOK,
+			send:   func(p config.PingConfig) { Finish(p, errors.New("failed to backup app: connection reset")) },
+			want:   []request{{http.MethodPost, "/fail", "text/plain; charset=utf-8", "failed to backup app: connection reset"}},
+		},
+		{
+			// Failed pings are only logged
+			name:   "error status",
+			status: http.StatusInternalServerError,
+			send:   Start,
+			want:   []request{{http.MethodPost, "/start", "text/plain; charset=utf-8", ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newTestServer(t, tt.status)
+			tt.send(config.PingConfig{Start: server.URL + "/start", Success: server.URL + "/success", Fail: server.URL + "/fail"})
+			if got := requests(); !slices.Equal(got, tt.want) {
+				t.Errorf("requests = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPingWithoutURL(t *testing.T) {
+	server, requests := newTestServer(t, http.StatusOK)
+
+	// Only the configured URLs are pinged
+	p := config.PingConfig{Fail: server.URL + "/fail"}
+	Start(p)
+	Finish(p, nil)
+	Start(config.PingConfig{})
+	Finish(config.PingConfig{}, errors.New("failed"))
+
+	if got := requests(); len(got) != 0 {
+		t.Errorf("requests = %+v, want none", got)
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	t.Cleanup(server.Close)
+
+	original := timeout
+	timeout = 50 * time.Millisecond
+	t.Cleanup(func() { timeout = original })
+
+	// A hanging ping endpoint does not block the job
+	started := time.Now()
+	Start(config.PingConfig{Start: server.URL})
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("ping took %s, want it to give up after the timeout", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("ping request was not cancelled")
+	}
+}
@@ -0,0 +1,93 @@
+package progress
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+)
+
+// Progress of a running backup or S3 upload, percent and totals are 0 while unknown
+type Progress struct {
+	Job              string    `json:"job"`
+	Backup           string    `json:"backup"`
+	PercentDone      float64   `json:"percent_done"`
+	BytesDone        int64     `json:"bytes_done"`
+	TotalBytes       int64     `json:"total_bytes"`
+	FilesDone        int       `json:"files_done"`
+	TotalFiles       int       `json:"total_files"`
+	SecondsRemaining int       `json:"seconds_remaining"`
+	StartedAt        time.Time `json:"started_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Tracker keeps the progress of running jobs and mirrors it to the metrics
+type Tracker struct {
+	mu      sync.Mutex
+	metrics *metrics.Metrics
+	running map[string]Progress
+}
+
+func NewTracker(m *metrics.Metrics) *Tracker {
+	return &Tracker{
+		metrics: m,
+		running: map[string]Progress{},
+	}
+}
+
+func key(job, backup string) string {
+	return job + ":" + backup
+}
+
+func (t *Tracker) Start(job, backup string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.running[key(job, backup)] = Progress{Job: job, Backup: backup, StartedAt: now, UpdatedAt: now}
+	t.metrics.SetJobProgress(job, backup, 0, 0, 0, 0)
+}
+
+func (t *Tracker) Update(p Progress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, ok := t.running[key(p.Job, p.Backup)]
+	if !ok {
+		return
+	}
+	p.StartedAt = current.StartedAt
+	p.UpdatedAt = time.Now()
+
+	// Estimate the remaining time from the elapsed time if the job does not report it
+	if p.SecondsRemaining == 0 && p.PercentDone > 0 && p.PercentDone < 1 {
+		elapsed := p.UpdatedAt.Sub(p.StartedAt).Seconds()
+		p.SecondsRemaining = int(elapsed / p.PercentDone * (1 - p.PercentDone))
+	}
+
+	t.running[key(p.Job, p.Backup)] = p
+	t.metrics.SetJobProgress(p.Job, p.Backup, p.PercentDone, p.BytesDone, p.FilesDone, p.SecondsRemaining)
+}
+
+func (t *Tracker) Finish(job, backup string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.running, key(job, backup))
+	t.metrics.DeleteJobProgress(job, backup)
+}
+
+func (t *Tracker) List() []Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := make([]Progress, 0, len(t.running))
+	for _, p := range t.running {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+	return list
+}
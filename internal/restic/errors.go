@@ -0,0 +1,141 @@
+package restic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/korbiniankuhn/auto-restic/internal/utils"
+)
+
+var (
+	ErrCommandFailed    = errors.New("restic command failed")
+	ErrRepoNotFound     = errors.New("repository does not exist")
+	ErrRepositoryLocked = errors.New("repository is locked")
+	ErrWrongPassword    = errors.New("wrong password or no key found")
+	ErrSourceUnreadable = errors.New("source data can not be read")
+	ErrPartialBackup    = errors.New("backup is incomplete, some source files could not be read")
+	ErrInterrupted      = errors.New("restic was interrupted")
+	ErrSourceCommand    = errors.New("source command failed")
+)
+
+// Error is a failed restic command classified by its exit code and output
+type Error struct {
+	// Kind is one of the Err* values
+	Kind     error
+	ExitCode int
+	Message  string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s (exit code %d)", e.Kind, e.ExitCode)
+	}
+	return fmt.Sprintf("%s (exit code %d): %s", e.Kind, e.ExitCode, e.Message)
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+type errorMessageJson struct {
+	MessageType string `json:"message_type"`
+	Code        int    `json:"code"`
+	Message     string `json:"message"`
+	Error       struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Item string `json:"item"`
+}
+
+// newError classifies the error of a restic command with its output, cancelled or timed out
+// commands are also marked with the context error
+func newError(ctx context.Context, err error, output []byte) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return utils.ContextError(ctx, err)
+	}
+	if len(exitErr.Stderr) > 0 {
+		output = append(output, exitErr.Stderr...)
+	}
+
+	e := &Error{
+		ExitCode: exitErr.ExitCode(),
+		Err:      err,
+	}
+
+	// restic prints fatal errors as "Fatal: ..." or as JSON exit error with --json
+	unreadable := []string{}
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var message errorMessageJson
+		if json.Unmarshal([]byte(line), &message) == nil && message.MessageType != "" {
+			switch message.MessageType {
+			case "exit_error":
+				e.Message = message.Message
+			case "error":
+				unreadable = append(unreadable, fmt.Sprintf("%s: %s", message.Item, message.Error.Message))
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "Fatal: ") {
+			e.Message = line
+		}
+		lines = append(lines, line)
+	}
+	if e.Message == "" && len(lines) > 0 {
+		e.Message = lines[len(lines)-1]
+	}
+
+	switch e.ExitCode {
+	case 3:
+		e.Kind = ErrPartialBackup
+		if len(unreadable) > 0 {
+			e.Message = strings.Join(unreadable, ", ")
+		}
+	case 10:
+		e.Kind = ErrRepoNotFound
+	case 11:
+		e.Kind = ErrRepositoryLocked
+	case 12:
+		e.Kind = ErrWrongPassword
+	case 130:
+		e.Kind = ErrInterrupted
+	default:
+		e.Kind = classifyMessage(e.Message)
+	}
+
+	return utils.ContextError(ctx, e)
+}
+
+// classifyMessage detects the error kind of fatal errors with exit code 1, restic versions before 0.17 use it for all fatal errors
+func classifyMessage(message string) error {
+	switch {
+	case strings.Contains(message, "unable to open config file"), strings.Contains(message, "repository does not exist"):
+		return ErrRepoNotFound
+	case strings.Contains(message, "repository is already locked"):
+		return ErrRepositoryLocked
+	case strings.Contains(message, "wrong password or no key found"):
+		return ErrWrongPassword
+	case strings.Contains(message, "all source directories/files do not exist"), strings.Contains(message, "all target directories/files do not exist"):
+		return ErrSourceUnreadable
+	// Command of --stdin-from-command exited with a non-zero code
+	case strings.Contains(message, "command failed"):
+		return ErrSourceCommand
+	default:
+		return ErrCommandFailed
+	}
+}
@@ -0,0 +1,99 @@
+package restic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+// exitError returns the error of a process that exited with code
+func exitError(t *testing.T, code int) error {
+	t.Helper()
+	err := exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+	if code != 0 && err == nil {
+		t.Fatalf("expected exit code %d", code)
+	}
+	return err
+}
+
+func TestNewError(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    int
+		output  string
+		kind    error
+		message string
+	}{
+		{name: "success", code: 0},
+		{name: "fatal", code: 1, output: "open repository\nFatal: unable to save snapshot: connection reset\n", kind: ErrCommandFailed, message: "Fatal: unable to save snapshot: connection reset"},
+		{name: "last line without fatal", code: 1, output: "first\nsomething went wrong\n", kind: ErrCommandFailed, message: "something went wrong"},
+		{name: "missing repository before 0.17", code: 1, output: "Fatal: unable to open config file: stat /repo/config: no such file or directory\n", kind: ErrRepoNotFound},
+		{name: "locked before 0.17", code: 1, output: "Fatal: unable to create lock in backend: repository is already locked by PID 1\n", kind: ErrRepositoryLocked},
+		{name: "wrong password before 0.17", code: 1, output: "Fatal: wrong password or no key found\n", kind: ErrWrongPassword},
+		{name: "unreadable source", code: 1, output: "Fatal: all source directories/files do not exist\n", kind: ErrSourceUnreadable},
+		{name: "source command", code: 1, output: "Fatal: unable to save snapshot: command failed: exit status 2\n", kind: ErrSourceCommand},
+		{name: "json exit error", code: 1, output: `{"message_type":"status","percent_done":0.5}` + "\n" + `{"message_type":"exit_error","code":1,"message":"Fatal: unable to save snapshot: command failed: exit status 1"}` + "\n", kind: ErrSourceCommand, message: "Fatal: unable to save snapshot: command failed: exit status 1"},
+		{
+			name:    "partial",
+			code:    3,
+			output:  `{"message_type":"error","error":{"message":"permission denied"},"during":"archival","item":"/data/secret"}` + "\n" + `{"message_type":"error","error":{"message":"no such file"},"item":"/data/gone"}` + "\n",
+			kind:    ErrPartialBackup,
+			message: "/data/secret: permission denied, /data/gone: no such file",
+		},
+		{name: "repository not found", code: 10, output: "Fatal: repository does not exist\n", kind: ErrRepoNotFound},
+		{name: "locked", code: 11, kind: ErrRepositoryLocked},
+		{name: "wrong password", code: 12, kind: ErrWrongPassword},
+		{name: "interrupted", code: 130, kind: ErrInterrupted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cause := exitError(t, tt.code)
+			err := newError(context.Background(), cause, []byte(tt.output))
+			if tt.kind == nil {
+				if err != nil {
+					t.Fatalf("error = %v, want nil", err)
+				}
+				return
+			}
+
+			// Both the kind and the original error are reachable through Unwrap
+			if !errors.Is(err, tt.kind) {
+				t.Errorf("error = %v, want kind %v", err, tt.kind)
+			}
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != tt.code {
+				t.Errorf("error = %v, want the exit error with code %d", err, tt.code)
+			}
+			var resticErr *Error
+			if !errors.As(err, &resticErr) {
+				t.Fatalf("error = %v, want an *Error", err)
+			}
+			if resticErr.ExitCode != tt.code {
+				t.Errorf("exit code = %d, want %d", resticErr.ExitCode, tt.code)
+			}
+			if tt.message != "" && resticErr.Message != tt.message {
+				t.Errorf("message = %q, want %q", resticErr.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestNewErrorWithoutExitCode(t *testing.T) {
+	cause := errors.New(`exec: "restic": executable file not found in $PATH`)
+	if err := newError(context.Background(), cause, nil); err != cause {
+		t.Errorf("error = %v, want %v", err, cause)
+	}
+}
+
+func TestNewErrorCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := newError(ctx, exitError(t, 130), nil)
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrInterrupted) {
+		t.Errorf("error = %v, want the context error and the interruption", err)
+	}
+}
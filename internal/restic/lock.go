@@ -0,0 +1,75 @@
+package restic
+
+import (
+	"context"
+	"sync"
+)
+
+// repoLock mirrors the locks of restic within the process, so concurrent jobs wait for each other
+// instead of failing with a locked repository. It is held by any number of shared holders (backups,
+// dumps and restores) or one exclusive holder (check, forget, prune). A waiting exclusive holder
+// blocks new shared holders.
+type repoLock struct {
+	mu               sync.Mutex
+	shared           int
+	exclusive        bool
+	waitingExclusive int
+	// Closed and replaced whenever the lock is released
+	released chan struct{}
+}
+
+func newRepoLock() *repoLock {
+	return &repoLock{released: make(chan struct{})}
+}
+
+func (l *repoLock) acquire(ctx context.Context, exclusive bool) (release func(), err error) {
+	l.mu.Lock()
+	if exclusive {
+		l.waitingExclusive++
+	}
+	for {
+		if exclusive && !l.exclusive && l.shared == 0 {
+			l.waitingExclusive--
+			l.exclusive = true
+			l.mu.Unlock()
+			return func() { l.release(true) }, nil
+		}
+		if !exclusive && !l.exclusive && l.waitingExclusive == 0 {
+			l.shared++
+			l.mu.Unlock()
+			return func() { l.release(false) }, nil
+		}
+
+		released := l.released
+		l.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			l.mu.Lock()
+			if exclusive {
+				l.waitingExclusive--
+				l.notify()
+			}
+			l.mu.Unlock()
+			return nil, ctx.Err()
+		}
+		l.mu.Lock()
+	}
+}
+
+func (l *repoLock) release(exclusive bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if exclusive {
+		l.exclusive = false
+	} else {
+		l.shared--
+	}
+	l.notify()
+}
+
+func (l *repoLock) notify() {
+	close(l.released)
+	l.released = make(chan struct{})
+}
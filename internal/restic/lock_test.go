@@ -0,0 +1,160 @@
+package restic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// acquireAsync acquires the lock in the background, the channel receives the release function
+func acquireAsync(l *repoLock, exclusive bool) <-chan func() {
+	acquired := make(chan func(), 1)
+	go func() {
+		release, err := l.acquire(context.Background(), exclusive)
+		if err == nil {
+			acquired <- release
+		}
+	}()
+	return acquired
+}
+
+// waitForExclusiveWaiters waits until n exclusive lockers are waiting
+func waitForExclusiveWaiters(t *testing.T, l *repoLock, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		waiting := l.waitingExclusive
+		l.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d exclusive waiters", n)
+}
+
+func assertNotAcquired(t *testing.T, acquired <-chan func(), message string) {
+	t.Helper()
+	select {
+	case <-acquired:
+		t.Fatal(message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func assertAcquired(t *testing.T, acquired <-chan func(), message string) func() {
+	t.Helper()
+	select {
+	case release := <-acquired:
+		return release
+	case <-time.After(5 * time.Second):
+		t.Fatal(message)
+		return nil
+	}
+}
+
+func TestRepoLockShared(t *testing.T) {
+	l := newRepoLock()
+	first, err := l.acquire(context.Background(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := l.acquire(context.Background(), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first()
+	second()
+
+	if l.shared != 0 || l.exclusive {
+		t.Errorf("lock is still held: shared %d, exclusive %t", l.shared, l.exclusive)
+	}
+}
+
+func TestRepoLockExclusiveWaitsForShared(t *testing.T) {
+	l := newRepoLock()
+	shared, _ := l.acquire(context.Background(), false)
+
+	exclusive := acquireAsync(l, true)
+	assertNotAcquired(t, exclusive, "exclusive lock acquired while a shared holder is running")
+
+	shared()
+	release := assertAcquired(t, exclusive, "exclusive lock not acquired after the shared holder released it")
+
+	// Shared lockers wait for the exclusive holder as well
+	next := acquireAsync(l, false)
+	assertNotAcquired(t, next, "shared lock acquired while the exclusive holder is running")
+	release()
+	assertAcquired(t, next, "shared lock not acquired after the exclusive holder released it")()
+}
+
+func TestRepoLockPrefersWriters(t *testing.T) {
+	l := newRepoLock()
+	first, _ := l.acquire(context.Background(), false)
+
+	exclusive := acquireAsync(l, true)
+	waitForExclusiveWaiters(t, l, 1)
+
+	// A new backup queues behind the waiting prune instead of starving it
+	shared := acquireAsync(l, false)
+	assertNotAcquired(t, shared, "shared lock acquired while an exclusive locker is waiting")
+
+	first()
+	release := assertAcquired(t, exclusive, "exclusive lock not acquired after the shared holder released it")
+	assertNotAcquired(t, shared, "shared lock acquired while the exclusive holder is running")
+
+	release()
+	assertAcquired(t, shared, "shared lock not acquired after the exclusive holder released it")()
+}
+
+func TestRepoLockCancelWhileWaiting(t *testing.T) {
+	l := newRepoLock()
+	shared, _ := l.acquire(context.Background(), false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.acquire(ctx, true)
+		done <- err
+	}()
+	waitForExclusiveWaiters(t, l, 1)
+
+	// A new backup waits for the exclusive locker until it gives up
+	next := acquireAsync(l, false)
+	assertNotAcquired(t, next, "shared lock acquired while an exclusive locker is waiting")
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want %v", err, context.Canceled)
+	}
+	release := assertAcquired(t, next, "shared lock not acquired after the exclusive locker gave up")
+
+	l.mu.Lock()
+	if l.waitingExclusive != 0 || l.exclusive || l.shared != 2 {
+		t.Errorf("counters after cancel: waiting %d, exclusive %t, shared %d", l.waitingExclusive, l.exclusive, l.shared)
+	}
+	l.mu.Unlock()
+
+	shared()
+	release()
+	if _, err := l.acquire(context.Background(), true); err != nil {
+		t.Errorf("exclusive lock not acquired on a free lock: %v", err)
+	}
+}
+
+func TestRepoLockCancelSharedWhileWaiting(t *testing.T) {
+	l := newRepoLock()
+	exclusive, _ := l.acquire(context.Background(), true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	exclusive()
+	if l.shared != 0 || l.exclusive || l.waitingExclusive != 0 {
+		t.Errorf("counters after cancel: waiting %d, exclusive %t, shared %d", l.waitingExclusive, l.exclusive, l.shared)
+	}
+}
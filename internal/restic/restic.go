@@ -1,27 +1,57 @@
 package restic
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
 	"time"
 )
 
 type Restic struct {
-	repository string
-	password   string
+	name    string
+	options Options
+	lock    *repoLock
 }
 
-func NewRestic(path, password string) (Restic, error) {
+type Options struct {
+	Repository string
+	// Only one of the password sources is passed to restic
+	Password        string
+	PasswordFile    string
+	PasswordCommand string
+	// Backend credentials, e.g. AWS_ACCESS_KEY_ID=...
+	Env []string
+}
+
+// Repositories are the configured repositories in the order of the config
+type Repositories []Restic
+
+func (r Repositories) Get(name string) (Restic, error) {
+	for _, repository := range r {
+		if repository.name == name {
+			return repository, nil
+		}
+	}
+	return Restic{}, fmt.Errorf("unknown repository: %s", name)
+}
+
+func NewRestic(ctx context.Context, name string, options Options) (Restic, error) {
 	r := Restic{
-		repository: path,
-		password:   password,
+		name:    name,
+		options: options,
+		lock:    newRepoLock(),
 	}
 
-	cmd := exec.Command("restic", "snapshots", "--latest=1", "--no-lock")
-	cmd.Env = r.getCommandEnv()
+	cmd := r.command(ctx, "snapshots", "--latest=1", "--no-lock")
 
 	output, err := cmd.CombinedOutput()
 
@@ -29,63 +59,217 @@ func NewRestic(path, password string) (Restic, error) {
 		return r, nil
 	}
 
-	if strings.Contains(string(output), "unable to open config file") {
-		err = r.init()
+	err = newError(ctx, err, output)
+	if errors.Is(err, ErrRepoNotFound) {
+		err = r.init(ctx)
 		if err != nil {
 			return Restic{}, fmt.Errorf("failed to initialize restic repository: %w", err)
 		}
-	} else if strings.Contains(string(output), "wrong password or no key found") {
-		return Restic{}, fmt.Errorf("restic password is incorrect: %w %s", err, output)
+	} else if errors.Is(err, ErrWrongPassword) {
+		return Restic{}, fmt.Errorf("restic password is incorrect: %w", err)
 	} else {
-		return Restic{}, fmt.Errorf("failed to run restic command: %w %s", err, output)
+		return Restic{}, fmt.Errorf("failed to run restic command: %w", err)
 	}
 
 	return r, nil
 }
 
+func (r Restic) Name() string {
+	return r.name
+}
+
+// Password variables of the process are dropped, restic fails if a file and a command are set
+var passwordEnvs = []string{"RESTIC_PASSWORD", "RESTIC_PASSWORD_FILE", "RESTIC_PASSWORD_COMMAND"}
+
 func (r Restic) getCommandEnv() []string {
-	env := os.Environ()
-	env = append(env, fmt.Sprintf("RESTIC_REPOSITORY=%s", r.repository))
-	env = append(env, fmt.Sprintf("RESTIC_PASSWORD=%s", r.password))
-	return env
+	env := []string{}
+	for _, e := range os.Environ() {
+		key, _, _ := strings.Cut(e, "=")
+		if !slices.Contains(passwordEnvs, key) {
+			env = append(env, e)
+		}
+	}
+
+	env = append(env, fmt.Sprintf("RESTIC_REPOSITORY=%s", r.options.Repository))
+	switch {
+	case r.options.PasswordFile != "":
+		env = append(env, fmt.Sprintf("RESTIC_PASSWORD_FILE=%s", r.options.PasswordFile))
+	case r.options.PasswordCommand != "":
+		env = append(env, fmt.Sprintf("RESTIC_PASSWORD_COMMAND=%s", r.options.PasswordCommand))
+	default:
+		env = append(env, fmt.Sprintf("RESTIC_PASSWORD=%s", r.options.Password))
+	}
+	// Later values win, so the backend credentials replace those of the process
+	return append(env, r.options.Env...)
 }
 
-func (r Restic) init() error {
-	cmd := exec.Command("restic", "init")
+func (r Restic) command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "restic", args...)
 	cmd.Env = r.getCommandEnv()
 
-	_, err := cmd.Output()
+	// Interrupt instead of kill on cancellation, so restic can remove its lock
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = 30 * time.Second
+
+	return cmd
+}
+
+func (r Restic) init(ctx context.Context) error {
+	cmd := r.command(ctx, "init")
+
+	output, err := cmd.Output()
 
 	if err != nil {
-		return fmt.Errorf("failed to initialize restic repository %s: %w", r.repository, err)
+		return fmt.Errorf("failed to initialize restic repository %s: %w", r.options.Repository, newError(ctx, err, output))
 	}
 
 	return nil
 }
 
-func (r Restic) BackupDirectory(name, path, exclude, excludeFile string) error {
-	args := []string{"backup", path, "--tag", fmt.Sprintf("name=%s", name), "--json"}
-	if exclude != "" {
+type backupMessageJson struct {
+	MessageType string `json:"message_type"`
+}
+
+type BackupStatus struct {
+	PercentDone      float64 `json:"percent_done"`
+	TotalFiles       int     `json:"total_files"`
+	FilesDone        int     `json:"files_done"`
+	TotalBytes       int64   `json:"total_bytes"`
+	BytesDone        int64   `json:"bytes_done"`
+	ErrorCount       int     `json:"error_count"`
+	SecondsElapsed   int     `json:"seconds_elapsed"`
+	SecondsRemaining int     `json:"seconds_remaining"`
+}
+
+type BackupOptions struct {
+	Paths             []string
+	Excludes          []string
+	IExcludes         []string
+	ExcludeFiles      []string
+	ExcludeIfPresent  []string
+	ExcludeLargerThan string
+	OneFileSystem     bool
+	FilesFrom         []string
+}
+
+func (o BackupOptions) args() []string {
+	args := append([]string{}, o.Paths...)
+	for _, exclude := range o.Excludes {
 		args = append(args, "--exclude", exclude)
 	}
-	if excludeFile != "" {
-		args = append(args, "--exclude-file", excludeFile)
+	for _, exclude := range o.IExcludes {
+		args = append(args, "--iexclude", exclude)
+	}
+	for _, file := range o.ExcludeFiles {
+		args = append(args, "--exclude-file", file)
+	}
+	for _, file := range o.ExcludeIfPresent {
+		args = append(args, "--exclude-if-present", file)
+	}
+	if o.ExcludeLargerThan != "" {
+		args = append(args, "--exclude-larger-than", o.ExcludeLargerThan)
 	}
+	if o.OneFileSystem {
+		args = append(args, "--one-file-system")
+	}
+	for _, file := range o.FilesFrom {
+		args = append(args, "--files-from", file)
+	}
+	return args
+}
 
-	cmd := exec.Command("restic", args...)
-	cmd.Env = r.getCommandEnv()
+// SharedLock waits until the repository can be shared with other backups, release must be called
+// once the backup is done. Backups hold it while their containers are suspended, so they never wait
+// for a prune with the containers down.
+func (r Restic) SharedLock(ctx context.Context) (release func(), err error) {
+	release, err = r.lock.acquire(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for repository lock: %w", err)
+	}
+	return release, nil
+}
 
-	output, err := cmd.CombinedOutput()
+// BackupDirectory creates a snapshot of the paths of the options, progress is called with every status message of restic if set.
+// The caller must hold SharedLock.
+func (r Restic) BackupDirectory(ctx context.Context, name string, options BackupOptions, progress func(BackupStatus)) (BackupSummary, error) {
+	return r.backup(ctx, name, options.args(), nil, progress)
+}
 
+// BackupCommandOutput stores the stdout of command as file filename in a snapshot, a non-zero exit code
+// of the command fails the backup without creating a snapshot. env is added to the environment of the command.
+// The caller must hold SharedLock.
+func (r Restic) BackupCommandOutput(ctx context.Context, name, filename string, command []string, env []string, progress func(BackupStatus)) (BackupSummary, error) {
+	// The command is a child of restic and would inherit the credentials of the repository
+	hidden := []string{"env", "-u", "RESTIC_REPOSITORY"}
+	for _, key := range passwordEnvs {
+		hidden = append(hidden, "-u", key)
+	}
+	for _, e := range r.options.Env {
+		key, _, _ := strings.Cut(e, "=")
+		hidden = append(hidden, "-u", key)
+	}
+
+	args := append([]string{"--stdin-from-command", "--stdin-filename", filename, "--"}, append(hidden, command...)...)
+	return r.backup(ctx, name, args, env, progress)
+}
+
+func (r Restic) backup(ctx context.Context, name string, args []string, env []string, progress func(BackupStatus)) (BackupSummary, error) {
+	args = append([]string{"backup", "--tag", fmt.Sprintf("name=%s", name), "--json"}, args...)
+
+	cmd := r.command(ctx, args...)
+	cmd.Env = append(cmd.Env, env...)
+	// Limit the status messages to one per second
+	cmd.Env = append(cmd.Env, "RESTIC_PROGRESS_FPS=1")
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("failed to backup directory %s: %w %s", path, err, output)
+		return BackupSummary{}, fmt.Errorf("failed to read restic backup output: %w", err)
 	}
 
-	return nil
+	err = cmd.Start()
+	if err != nil {
+		return BackupSummary{}, fmt.Errorf("failed to start restic backup: %w", err)
+	}
+
+	// restic streams one JSON message per line, status messages while running and a summary at the end
+	summary := BackupSummary{}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var message backupMessageJson
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			continue
+		}
+
+		switch message.MessageType {
+		case "status":
+			var status BackupStatus
+			if err := json.Unmarshal(scanner.Bytes(), &status); err == nil && progress != nil {
+				progress(status)
+			}
+		case "summary":
+			if err := json.Unmarshal(scanner.Bytes(), &summary); err != nil {
+				slog.Warn("failed to parse restic backup summary", "name", name, "error", err)
+			}
+		}
+	}
+	// Drain the remaining output, so restic does not block on writing it
+	_, _ = io.Copy(io.Discard, stdout)
+
+	err = cmd.Wait()
+	if err != nil {
+		return summary, fmt.Errorf("failed to backup %s: %w", name, newError(ctx, err, stderr.Bytes()))
+	}
+
+	return summary, nil
 }
 
-func (r Restic) RemoveBackupDirectory(name string) (string, error) {
-	snapshots, err := r.listSnapshotsByName(name)
+func (r Restic) RemoveBackupDirectory(ctx context.Context, name string) (string, error) {
+	snapshots, err := r.listSnapshotsByName(ctx, name)
 
 	if err != nil {
 		return "", fmt.Errorf("failed to list snapshots by name: %w", err)
@@ -100,41 +284,127 @@ func (r Restic) RemoveBackupDirectory(name string) (string, error) {
 		ids = append(ids, s.ShortID)
 	}
 
+	release, err := r.lock.acquire(ctx, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to wait for repository lock: %w", err)
+	}
+	defer release()
+
 	args := append([]string{"forget", "--prune", "--json"}, ids...)
 
-	cmd := exec.Command("restic", args...)
-	cmd.Env = r.getCommandEnv()
+	cmd := r.command(ctx, args...)
 
 	output, err := cmd.CombinedOutput()
 
 	if err != nil {
-		return "", fmt.Errorf("failed to remove backup %s: %w %s", name, err, output)
+		return "", fmt.Errorf("failed to remove backup %s: %w", name, newError(ctx, err, output))
 	}
 
 	return string(output), nil
 }
 
-func (r Restic) Check() error {
-	cmd := exec.Command("restic", "check")
-	cmd.Env = r.getCommandEnv()
+func (r Restic) Check(ctx context.Context) error {
+	release, err := r.lock.acquire(ctx, true)
+	if err != nil {
+		return fmt.Errorf("failed to wait for repository lock: %w", err)
+	}
+	defer release()
 
-	_, err := cmd.Output()
+	cmd := r.command(ctx, "check")
+
+	output, err := cmd.Output()
 
 	if err != nil {
-		return fmt.Errorf("failed to check restic repository: %w", err)
+		return fmt.Errorf("failed to check restic repository: %w", newError(ctx, err, output))
 	}
 
 	return nil
 }
 
-func (r Restic) ForgetAndPrune(keepDaily, keepWeekly, keepMonthly int) error {
-	cmd := exec.Command("restic", "forget", "--prune", fmt.Sprintf("--keep-daily=%d", keepDaily), fmt.Sprintf("--keep-weekly=%d", keepWeekly), fmt.Sprintf("--keep-monthly=%d", keepMonthly))
-	cmd.Env = r.getCommandEnv()
+// Unlock removes stale locks, e.g. left behind by a restic process that was killed
+func (r Restic) Unlock(ctx context.Context) error {
+	cmd := r.command(ctx, "unlock")
 
-	_, err := cmd.Output()
+	output, err := cmd.Output()
 
 	if err != nil {
-		return fmt.Errorf("failed to forget old backups: %w", err)
+		return fmt.Errorf("failed to unlock restic repository: %w", newError(ctx, err, output))
+	}
+
+	return nil
+}
+
+type RetentionPolicy struct {
+	KeepLast    int
+	KeepHourly  int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	KeepYearly  int
+	KeepWithin  string
+}
+
+func (p RetentionPolicy) args() []string {
+	args := []string{}
+	if p.KeepLast > 0 {
+		args = append(args, fmt.Sprintf("--keep-last=%d", p.KeepLast))
+	}
+	if p.KeepHourly > 0 {
+		args = append(args, fmt.Sprintf("--keep-hourly=%d", p.KeepHourly))
+	}
+	if p.KeepDaily > 0 {
+		args = append(args, fmt.Sprintf("--keep-daily=%d", p.KeepDaily))
+	}
+	if p.KeepWeekly > 0 {
+		args = append(args, fmt.Sprintf("--keep-weekly=%d", p.KeepWeekly))
+	}
+	if p.KeepMonthly > 0 {
+		args = append(args, fmt.Sprintf("--keep-monthly=%d", p.KeepMonthly))
+	}
+	if p.KeepYearly > 0 {
+		args = append(args, fmt.Sprintf("--keep-yearly=%d", p.KeepYearly))
+	}
+	if p.KeepWithin != "" {
+		args = append(args, fmt.Sprintf("--keep-within=%s", p.KeepWithin))
+	}
+	return args
+}
+
+func (r Restic) ForgetByName(ctx context.Context, name string, policy RetentionPolicy) error {
+	release, err := r.lock.acquire(ctx, true)
+	if err != nil {
+		return fmt.Errorf("failed to wait for repository lock: %w", err)
+	}
+	defer release()
+
+	// The name tag already selects the snapshots of one backup, grouping them by their tags, host or
+	// paths would let snapshots with an extra tag, another hostname or changed paths escape the policy
+	args := append([]string{"forget", "--tag", fmt.Sprintf("name=%s", name), "--group-by", ""}, policy.args()...)
+
+	cmd := r.command(ctx, args...)
+
+	output, err := cmd.CombinedOutput()
+
+	if err != nil {
+		return fmt.Errorf("failed to forget old backups of %s: %w", name, newError(ctx, err, output))
+	}
+
+	return nil
+}
+
+func (r Restic) Prune(ctx context.Context) error {
+	release, err := r.lock.acquire(ctx, true)
+	if err != nil {
+		return fmt.Errorf("failed to wait for repository lock: %w", err)
+	}
+	defer release()
+
+	cmd := r.command(ctx, "prune")
+
+	output, err := cmd.CombinedOutput()
+
+	if err != nil {
+		return fmt.Errorf("failed to prune restic repository: %w", newError(ctx, err, output))
 	}
 
 	return nil
@@ -150,14 +420,17 @@ type snapshotJson struct {
 	Tags           []string      `json:"tags"`
 	ProgramVersion string        `json:"program_version"`
 	Excludes       []string      `json:"excludes"`
-	Summary        backupSummary `json:"summary"`
+	Summary        BackupSummary `json:"summary"`
 	ID             string        `json:"id"`
 	ShortID        string        `json:"short_id"`
 }
 
-type backupSummary struct {
+// BackupSummary is the summary of a backup run, stored in the snapshot since restic 0.17
+type BackupSummary struct {
+	SnapshotID          string    `json:"snapshot_id"`
 	BackupStart         time.Time `json:"backup_start"`
 	BackupEnd           time.Time `json:"backup_end"`
+	TotalDuration       float64   `json:"total_duration"`
 	FilesNew            int       `json:"files_new"`
 	FilesChanged        int       `json:"files_changed"`
 	FilesUnmodified     int       `json:"files_unmodified"`
@@ -174,7 +447,8 @@ type backupSummary struct {
 
 type Snapshot struct {
 	snapshotJson
-	Name string
+	Name       string
+	Repository string
 }
 
 func (s snapshotJson) GetName() string {
@@ -186,21 +460,30 @@ func (s snapshotJson) GetName() string {
 	return s.ID
 }
 
-func (s snapshotJson) toInternalSnapshot() Snapshot {
+func (s snapshotJson) HasName() bool {
+	for _, tag := range s.Tags {
+		if strings.HasPrefix(tag, "name=") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s snapshotJson) toInternalSnapshot(repository string) Snapshot {
 	return Snapshot{
 		Name:         s.GetName(),
+		Repository:   repository,
 		snapshotJson: s,
 	}
 }
 
-func (r Restic) ListSnapshots() ([]Snapshot, error) {
-	cmd := exec.Command("restic", "snapshots", "--no-lock", "--json")
-	cmd.Env = r.getCommandEnv()
+func (r Restic) ListSnapshots(ctx context.Context) ([]Snapshot, error) {
+	cmd := r.command(ctx, "snapshots", "--no-lock", "--json")
 
 	output, err := cmd.CombinedOutput()
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to list snapshots: %w %s", err, output)
+		return nil, fmt.Errorf("failed to list snapshots: %w", newError(ctx, err, output))
 	}
 
 	var snapshotJsons []snapshotJson
@@ -211,20 +494,19 @@ func (r Restic) ListSnapshots() ([]Snapshot, error) {
 
 	snapshots := make([]Snapshot, len(snapshotJsons))
 	for i, snapshot := range snapshotJsons {
-		snapshots[i] = snapshot.toInternalSnapshot()
+		snapshots[i] = snapshot.toInternalSnapshot(r.name)
 	}
 
 	return snapshots, nil
 }
 
-func (r Restic) listSnapshotsByName(name string) ([]Snapshot, error) {
-	cmd := exec.Command("restic", "snapshots", "--tag", fmt.Sprintf("name=%s", name), "--no-lock", "--json")
-	cmd.Env = r.getCommandEnv()
+func (r Restic) listSnapshotsByName(ctx context.Context, name string) ([]Snapshot, error) {
+	cmd := r.command(ctx, "snapshots", "--tag", fmt.Sprintf("name=%s", name), "--no-lock", "--json")
 
 	output, err := cmd.CombinedOutput()
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to list snapshots by name: %w %s", err, output)
+		return nil, fmt.Errorf("failed to list snapshots by name: %w", newError(ctx, err, output))
 	}
 
 	var snapshotJsons []snapshotJson
@@ -235,20 +517,19 @@ func (r Restic) listSnapshotsByName(name string) ([]Snapshot, error) {
 
 	snapshots := make([]Snapshot, len(snapshotJsons))
 	for i, snapshot := range snapshotJsons {
-		snapshots[i] = snapshot.toInternalSnapshot()
+		snapshots[i] = snapshot.toInternalSnapshot(r.name)
 	}
 
 	return snapshots, nil
 }
 
-func (r Restic) ListLatestSnapshots() ([]Snapshot, error) {
-	cmd := exec.Command("restic", "snapshots", "--latest=1", "--no-lock", "--json")
-	cmd.Env = r.getCommandEnv()
+func (r Restic) ListLatestSnapshots(ctx context.Context) ([]Snapshot, error) {
+	cmd := r.command(ctx, "snapshots", "--latest=1", "--no-lock", "--json")
 
 	output, err := cmd.CombinedOutput()
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to list snapshots: %w %s", err, output)
+		return nil, fmt.Errorf("failed to list snapshots: %w", newError(ctx, err, output))
 	}
 
 	var snapshots []snapshotJson
@@ -257,14 +538,76 @@ func (r Restic) ListLatestSnapshots() ([]Snapshot, error) {
 		return nil, fmt.Errorf("failed to unmarshal snapshots: %w", err)
 	}
 
-	snapshotList := make([]Snapshot, len(snapshots))
-	for i, snapshot := range snapshots {
-		snapshotList[i] = snapshot.toInternalSnapshot()
+	// --latest groups by host and paths, so a backup whose paths changed has several latest snapshots
+	latest := map[string]int{}
+	snapshotList := []Snapshot{}
+	for _, snapshot := range snapshots {
+		s := snapshot.toInternalSnapshot(r.name)
+		i, ok := latest[s.Name]
+		if !ok {
+			latest[s.Name] = len(snapshotList)
+			snapshotList = append(snapshotList, s)
+		} else if s.Time.After(snapshotList[i].Time) {
+			snapshotList[i] = s
+		}
 	}
 
 	return snapshotList, nil
 }
 
+// ListSnapshots lists the snapshots of all repositories
+func (r Repositories) ListSnapshots(ctx context.Context) ([]Snapshot, error) {
+	snapshots := []Snapshot{}
+	for _, repository := range r {
+		s, err := repository.ListSnapshots(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("repository %s: %w", repository.name, err)
+		}
+		snapshots = append(snapshots, s...)
+	}
+	return snapshots, nil
+}
+
+// FindSnapshot returns the repository that contains the snapshot with the ID or short ID
+func (r Repositories) FindSnapshot(ctx context.Context, id string) (Restic, error) {
+	// A single repository is left to restic, which reports an unknown snapshot itself
+	if len(r) == 1 {
+		return r[0], nil
+	}
+
+	snapshots, err := r.ListSnapshots(ctx)
+	if err != nil {
+		return Restic{}, err
+	}
+	found := []string{}
+	for _, snapshot := range snapshots {
+		if id != "" && strings.HasPrefix(snapshot.ID, id) && !slices.Contains(found, snapshot.Repository) {
+			found = append(found, snapshot.Repository)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Restic{}, fmt.Errorf("snapshot %s not found in any repository", id)
+	case 1:
+		return r.Get(found[0])
+	default:
+		return Restic{}, fmt.Errorf("snapshot %s is ambiguous, found in repositories %s", id, strings.Join(found, ", "))
+	}
+}
+
+// ListLatestSnapshots lists the latest snapshot per backup of all repositories
+func (r Repositories) ListLatestSnapshots(ctx context.Context) ([]Snapshot, error) {
+	snapshots := []Snapshot{}
+	for _, repository := range r {
+		s, err := repository.ListLatestSnapshots(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("repository %s: %w", repository.name, err)
+		}
+		snapshots = append(snapshots, s...)
+	}
+	return snapshots, nil
+}
+
 type SnapshotStats struct {
 	TotalSize              int     `json:"total_size"`
 	TotalUncompressedSize  int     `json:"total_uncompressed_size"`
@@ -275,13 +618,12 @@ type SnapshotStats struct {
 	SnapshotsCount         int     `json:"snapshots_count"`
 }
 
-func (r Restic) GetSnapshotStatsByName(name string) (SnapshotStats, error) {
-	cmd := exec.Command("restic", "stats", "--json", "--mode", "raw-data", "--no-lock", "--tag", fmt.Sprintf("name=%s", name))
-	cmd.Env = r.getCommandEnv()
+func (r Restic) GetSnapshotStatsByName(ctx context.Context, name string) (SnapshotStats, error) {
+	cmd := r.command(ctx, "stats", "--json", "--mode", "raw-data", "--no-lock", "--tag", fmt.Sprintf("name=%s", name))
 	output, err := cmd.CombinedOutput()
 
 	if err != nil {
-		return SnapshotStats{}, fmt.Errorf("failed to get snapshot stats: %w %s", err, output)
+		return SnapshotStats{}, fmt.Errorf("failed to get snapshot stats: %w", newError(ctx, err, output))
 	}
 
 	var stats SnapshotStats
@@ -293,13 +635,41 @@ func (r Restic) GetSnapshotStatsByName(name string) (SnapshotStats, error) {
 	return stats, nil
 }
 
-func (r Restic) Restore(snapshot, path string) error {
-	cmd := exec.Command("restic", "restore", snapshot, "--target", path, "--no-lock")
-	cmd.Env = r.getCommandEnv()
+func (r Restic) Restore(ctx context.Context, snapshot, path string) error {
+	// restic does not lock the repository, prune must not remove the data while it is read
+	release, err := r.lock.acquire(ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to wait for repository lock: %w", err)
+	}
+	defer release()
+
+	cmd := r.command(ctx, "restore", snapshot, "--target", path, "--no-lock")
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("failed to restore snapshot: %s %w %s", snapshot, err, output)
+		return fmt.Errorf("failed to restore snapshot %s: %w", snapshot, newError(ctx, err, output))
+	}
+
+	return nil
+}
+
+func (r Restic) Dump(ctx context.Context, snapshot string, w io.Writer) error {
+	// restic does not lock the repository, prune must not remove the data while it is read
+	release, err := r.lock.acquire(ctx, false)
+	if err != nil {
+		return fmt.Errorf("failed to wait for repository lock: %w", err)
+	}
+	defer release()
+
+	cmd := r.command(ctx, "dump", snapshot, "/", "--archive", "tar", "--no-lock")
+
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("failed to dump snapshot %s: %w", snapshot, newError(ctx, err, stderr.Bytes()))
 	}
 
 	return nil
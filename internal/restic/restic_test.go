@@ -0,0 +1,325 @@
+package restic
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Serves the snapshots of a local directory repository from its snapshots.json, records restores
+// and forgets and replays backup.out of the repository for backups
+const resticStub = `#!/bin/sh
+case "$1" in
+snapshots) cat "$RESTIC_REPOSITORY/snapshots.json" ;;
+restore) echo "$RESTIC_REPOSITORY $2" > "$4/restored" ;;
+forget) printf '%s|' "$@" > "$RESTIC_REPOSITORY/forget.args" ;;
+backup) echo "$@" > "$RESTIC_REPOSITORY/backup.args"; cat "$RESTIC_REPOSITORY/backup.out"; exit "${BACKUP_EXIT:-0}" ;;
+esac
+`
+
+// newTestRepositories creates a local directory repository per name with the snapshot IDs
+func newTestRepositories(t *testing.T, snapshots map[string][]string) Repositories {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "restic"), []byte(resticStub), 0o755); err != nil {
+		t.Fatalf("failed to write restic stub: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	repos := Repositories{}
+	for _, name := range []string{"default", "offsite", "local"} {
+		path := filepath.Join(dir, name)
+		if err := os.Mkdir(path, 0o755); err != nil {
+			t.Fatalf("failed to create repository: %v", err)
+		}
+		entries := []string{}
+		for _, id := range snapshots[name] {
+			entries = append(entries, `{"id":"`+id+`","short_id":"`+id[:8]+`","tags":["name=app"]}`)
+		}
+		if err := os.WriteFile(filepath.Join(path, "snapshots.json"), []byte("["+strings.Join(entries, ",")+"]"), 0o644); err != nil {
+			t.Fatalf("failed to write snapshots: %v", err)
+		}
+
+		r, err := NewRestic(context.Background(), name, Options{Repository: path, Password: "secret"})
+		if err != nil {
+			t.Fatalf("failed to create repository %s: %v", name, err)
+		}
+		repos = append(repos, r)
+	}
+	return repos
+}
+
+func TestFindSnapshot(t *testing.T) {
+	repos := newTestRepositories(t, map[string][]string{
+		"default": {"aaaaaaaa11111111"},
+		"offsite": {"bbbbbbbb22222222", "cccccccc33333333"},
+		"local":   {"cccccccc44444444"},
+	})
+
+	tests := []struct {
+		id         string
+		repository string
+		err        string
+	}{
+		{id: "aaaaaaaa11111111", repository: "default"},
+		{id: "bbbbbbbb", repository: "offsite"},
+		{id: "cccccccc4", repository: "local"},
+		{id: "cccccccc", err: "ambiguous"},
+		{id: "dddddddd", err: "not found"},
+		{id: "", err: "not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			r, err := repos.FindSnapshot(context.Background(), tt.id)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if r.Name() != tt.repository {
+				t.Errorf("repository = %s, want %s", r.Name(), tt.repository)
+			}
+		})
+	}
+}
+
+func TestRestoreFromFoundRepository(t *testing.T) {
+	repos := newTestRepositories(t, map[string][]string{
+		"default": {"aaaaaaaa11111111"},
+		"offsite": {"bbbbbbbb22222222"},
+	})
+
+	r, err := repos.FindSnapshot(context.Background(), "bbbbbbbb")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	target := t.TempDir()
+	if err := r.Restore(context.Background(), "bbbbbbbb", target); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restored, err := os.ReadFile(filepath.Join(target, "restored"))
+	if err != nil {
+		t.Fatalf("snapshot was not restored: %v", err)
+	}
+	if want := r.options.Repository + " bbbbbbbb\n"; string(restored) != want || !strings.HasSuffix(r.options.Repository, "offsite") {
+		t.Errorf("restored %q, want %q from the offsite repository", restored, want)
+	}
+}
+
+func TestFindSnapshotSingleRepository(t *testing.T) {
+	repos := newTestRepositories(t, nil)[:1]
+
+	// restic reports unknown snapshots of a single repository itself
+	r, err := repos.FindSnapshot(context.Background(), "dddddddd")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Name() != "default" {
+		t.Errorf("repository = %s, want default", r.Name())
+	}
+}
+
+// Output of restic 0.17 backup --json, mixed with noise of restic and the source command
+const backupOutput = `open repository
+{"message_type":"status","seconds_elapsed":1,"percent_done":0,"total_files":2,"total_bytes":2048}
+
+{"message_type":"status","seconds_elapsed":2,"seconds_remaining":3,"percent_done":0.5,"total_files":2,"files_done":1,"total_bytes":2048,"bytes_done":1024,"error_count":1,"current_files":["/data/b"]}
+{"message_type":"error","error":{"message":"permission denied"},"during":"archival","item":"/data/c"}
+{"message_type":"verbose_status","action":"new","item":"/data/a","duration":0.1,"data_size":1024}
+{"message_type":"status", broken
+{"message_type":"summary","dry_run":false,"files_new":1,"files_changed":2,"files_unmodified":3,"dirs_new":4,"dirs_changed":5,"dirs_unmodified":6,"data_blobs":7,"tree_blobs":8,"data_added":1024,"data_added_packed":512,"total_files_processed":6,"total_bytes_processed":2048,"total_duration":2.5,"backup_start":"2024-06-12T02:00:00.5+02:00","backup_end":"2024-06-12T02:00:03+02:00","snapshot_id":"0123456789abcdef"}
+`
+
+func TestBackupJSONOutput(t *testing.T) {
+	repos := newTestRepositories(t, nil)
+	r := repos[0]
+	if err := os.WriteFile(filepath.Join(r.options.Repository, "backup.out"), []byte(backupOutput), 0o644); err != nil {
+		t.Fatalf("failed to write backup output: %v", err)
+	}
+
+	statuses := []BackupStatus{}
+	summary, err := r.BackupDirectory(context.Background(), "app", BackupOptions{Paths: []string{"/data"}}, func(status BackupStatus) {
+		statuses = append(statuses, status)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Unknown fields and messages, noise and broken lines are skipped
+	wantStatuses := []BackupStatus{
+		{SecondsElapsed: 1, TotalFiles: 2, TotalBytes: 2048},
+		{SecondsElapsed: 2, SecondsRemaining: 3, PercentDone: 0.5, TotalFiles: 2, FilesDone: 1, TotalBytes: 2048, BytesDone: 1024, ErrorCount: 1},
+	}
+	if !slices.Equal(statuses, wantStatuses) {
+		t.Errorf("statuses = %+v, want %+v", statuses, wantStatuses)
+	}
+
+	zone := time.FixedZone("", 2*60*60)
+	wantSummary := BackupSummary{
+		SnapshotID:          "0123456789abcdef",
+		BackupStart:         time.Date(2024, 6, 12, 2, 0, 0, 500_000_000, zone),
+		BackupEnd:           time.Date(2024, 6, 12, 2, 0, 3, 0, zone),
+		TotalDuration:       2.5,
+		FilesNew:            1,
+		FilesChanged:        2,
+		FilesUnmodified:     3,
+		DirsNew:             4,
+		DirsChanged:         5,
+		DirsUnmodified:      6,
+		DataBlobs:           7,
+		TreeBlobs:           8,
+		DataAdded:           1024,
+		DataAddedPacked:     512,
+		TotalFilesProcessed: 6,
+		TotalBytesProcessed: 2048,
+	}
+	if !summary.BackupStart.Equal(wantSummary.BackupStart) || !summary.BackupEnd.Equal(wantSummary.BackupEnd) {
+		t.Errorf("backup from %s to %s, want %s to %s", summary.BackupStart, summary.BackupEnd, wantSummary.BackupStart, wantSummary.BackupEnd)
+	}
+	summary.BackupStart, summary.BackupEnd = wantSummary.BackupStart, wantSummary.BackupEnd
+	if summary != wantSummary {
+		t.Errorf("summary = %+v, want %+v", summary, wantSummary)
+	}
+
+	args, err := os.ReadFile(filepath.Join(r.options.Repository, "backup.args"))
+	if err != nil {
+		t.Fatalf("backup did not run: %v", err)
+	}
+	if want := "backup --tag name=app --json /data\n"; string(args) != want {
+		t.Errorf("args = %q, want %q", args, want)
+	}
+}
+
+func TestBackupJSONOutputPartial(t *testing.T) {
+	repos := newTestRepositories(t, nil)
+	r := repos[0]
+	if err := os.WriteFile(filepath.Join(r.options.Repository, "backup.out"), []byte(backupOutput), 0o644); err != nil {
+		t.Fatalf("failed to write backup output: %v", err)
+	}
+	t.Setenv("BACKUP_EXIT", "3")
+
+	// A partial backup still created a snapshot, its summary is returned with the error
+	summary, err := r.BackupDirectory(context.Background(), "app", BackupOptions{Paths: []string{"/data"}}, nil)
+	if !errors.Is(err, ErrPartialBackup) {
+		t.Errorf("error = %v, want %v", err, ErrPartialBackup)
+	}
+	if summary.SnapshotID != "0123456789abcdef" || summary.DataAdded != 1024 {
+		t.Errorf("summary = %+v, want snapshot 0123456789abcdef with 1024 bytes added", summary)
+	}
+}
+
+func TestRetentionPolicyArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []string
+	}{
+		{name: "empty", want: []string{}},
+		{name: "daily", policy: RetentionPolicy{KeepDaily: 7}, want: []string{"--keep-daily=7"}},
+		{
+			name:   "all",
+			policy: RetentionPolicy{KeepLast: 1, KeepHourly: 2, KeepDaily: 3, KeepWeekly: 4, KeepMonthly: 5, KeepYearly: 6, KeepWithin: "1y2m"},
+			want:   []string{"--keep-last=1", "--keep-hourly=2", "--keep-daily=3", "--keep-weekly=4", "--keep-monthly=5", "--keep-yearly=6", "--keep-within=1y2m"},
+		},
+		{name: "negative values are unset", policy: RetentionPolicy{KeepLast: -1, KeepWeekly: 4}, want: []string{"--keep-weekly=4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.args(); !slices.Equal(got, tt.want) {
+				t.Errorf("args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForgetByName(t *testing.T) {
+	r := newTestRepositories(t, nil)[0]
+	if err := r.ForgetByName(context.Background(), "app", RetentionPolicy{KeepDaily: 7, KeepWeekly: 4}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	args, err := os.ReadFile(filepath.Join(r.options.Repository, "forget.args"))
+	if err != nil {
+		t.Fatalf("forget did not run: %v", err)
+	}
+	// All snapshots of the name form one group, whatever other tags, host or paths they have
+	if want := "forget|--tag|name=app|--group-by||--keep-daily=7|--keep-weekly=4|"; string(args) != want {
+		t.Errorf("args = %q, want %q", args, want)
+	}
+}
+
+func TestGetCommandEnv(t *testing.T) {
+	// Credentials of the process must not leak into other repositories
+	t.Setenv("RESTIC_PASSWORD", "process")
+	t.Setenv("RESTIC_PASSWORD_FILE", "/process/file")
+	t.Setenv("RESTIC_PASSWORD_COMMAND", "process command")
+	t.Setenv("AWS_ACCESS_KEY_ID", "process-key")
+	t.Setenv("UNRELATED", "kept")
+
+	tests := []struct {
+		name    string
+		options Options
+		want    []string
+		missing []string
+	}{
+		{
+			name:    "password",
+			options: Options{Repository: "/data/restic", Password: "secret"},
+			want:    []string{"RESTIC_REPOSITORY=/data/restic", "RESTIC_PASSWORD=secret", "UNRELATED=kept"},
+			missing: []string{"RESTIC_PASSWORD_FILE", "RESTIC_PASSWORD_COMMAND"},
+		},
+		{
+			name:    "password file",
+			options: Options{Repository: "/data/restic", PasswordFile: "/run/secrets/restic"},
+			want:    []string{"RESTIC_PASSWORD_FILE=/run/secrets/restic"},
+			missing: []string{"RESTIC_PASSWORD", "RESTIC_PASSWORD_COMMAND"},
+		},
+		{
+			name:    "password command",
+			options: Options{Repository: "/data/restic", PasswordCommand: "pass restic"},
+			want:    []string{"RESTIC_PASSWORD_COMMAND=pass restic"},
+			missing: []string{"RESTIC_PASSWORD", "RESTIC_PASSWORD_FILE"},
+		},
+		{
+			name:    "backend env",
+			options: Options{Repository: "s3:s3.amazonaws.com/backups", Password: "secret", Env: []string{"AWS_ACCESS_KEY_ID=repo-key", "AWS_SECRET_ACCESS_KEY=repo-secret"}},
+			want:    []string{"RESTIC_REPOSITORY=s3:s3.amazonaws.com/backups", "AWS_ACCESS_KEY_ID=repo-key", "AWS_SECRET_ACCESS_KEY=repo-secret"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Restic{name: tt.name, options: tt.options}
+
+			// exec uses the last value of duplicate variables
+			env := map[string]string{}
+			for _, e := range r.getCommandEnv() {
+				key, value, _ := strings.Cut(e, "=")
+				env[key] = value
+			}
+			for _, e := range tt.want {
+				key, value, _ := strings.Cut(e, "=")
+				if got, ok := env[key]; !ok || got != value {
+					t.Errorf("%s = %q, want %q", key, got, value)
+				}
+			}
+			for _, key := range tt.missing {
+				if _, ok := env[key]; ok {
+					t.Errorf("%s is set", key)
+				}
+			}
+		})
+	}
+}
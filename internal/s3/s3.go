@@ -2,6 +2,7 @@ package s3
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -16,7 +17,7 @@ type S3 struct {
 	bucket string
 }
 
-func Get(accessKey, secretKey, endpoint, bucket string) (*S3, error) {
+func Get(ctx context.Context, accessKey, secretKey, endpoint, bucket string) (*S3, error) {
 	c, err := minio.New(endpoint, &minio.Options{
 		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
 		Secure: true,
@@ -26,7 +27,7 @@ func Get(accessKey, secretKey, endpoint, bucket string) (*S3, error) {
 		return nil, fmt.Errorf("invalid s3 credentials or endpoint: %w", err)
 	}
 
-	exists, err := c.BucketExists(context.TODO(), bucket)
+	exists, err := c.BucketExists(ctx, bucket)
 	if err != nil {
 		return nil, fmt.Errorf("unable to check if bucket exists: %w", err)
 	}
@@ -52,9 +53,9 @@ type S3Object struct {
 	Key            string
 }
 
-func (s3 S3) ListObjects() ([]S3Object, error) {
+func (s3 S3) ListObjects(ctx context.Context) ([]S3Object, error) {
 	objects := []S3Object{}
-	for obj := range s3.client.ListObjects(context.TODO(), s3.bucket, minio.ListObjectsOptions{
+	for obj := range s3.client.ListObjects(ctx, s3.bucket, minio.ListObjectsOptions{
 		WithVersions: true,
 		WithMetadata: true,
 	}) {
@@ -76,16 +77,24 @@ func (s3 S3) ListObjects() ([]S3Object, error) {
 	return objects, nil
 }
 
-func (s3 S3) StreamUploadFile(filename string, reader *io.PipeReader) error {
-	_, err := s3.client.PutObject(context.TODO(), s3.bucket, filename, reader, -1, minio.PutObjectOptions{})
+func (s3 S3) StreamUploadFile(ctx context.Context, filename string, reader *io.PipeReader) error {
+	_, err := s3.client.PutObject(ctx, s3.bucket, filename, reader, -1, minio.PutObjectOptions{})
 	if err != nil {
+		// A cancelled upload can not abort its multipart upload, remove the uploaded parts with a fresh context
+		if ctx.Err() != nil {
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if removeErr := s3.client.RemoveIncompleteUpload(cleanupCtx, s3.bucket, filename); removeErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to remove incomplete upload: %w", removeErr))
+			}
+		}
 		return fmt.Errorf("failed to upload file: %w", err)
 	}
 	return nil
 }
 
-func (s3 S3) RemoveObject(objectKey, versionID string) error {
-	err := s3.client.RemoveObject(context.TODO(), s3.bucket, objectKey, minio.RemoveObjectOptions{
+func (s3 S3) RemoveObject(ctx context.Context, objectKey, versionID string) error {
+	err := s3.client.RemoveObject(ctx, s3.bucket, objectKey, minio.RemoveObjectOptions{
 		VersionID: versionID,
 	})
 
@@ -95,8 +104,8 @@ func (s3 S3) RemoveObject(objectKey, versionID string) error {
 	return nil
 }
 
-func (s3 S3) StreamDownloadFile(objectKey, versionID string) (*minio.Object, error) {
-	obj, err := s3.client.GetObject(context.TODO(), s3.bucket, objectKey, minio.GetObjectOptions{
+func (s3 S3) StreamDownloadFile(ctx context.Context, objectKey, versionID string) (*minio.Object, error) {
+	obj, err := s3.client.GetObject(ctx, s3.bucket, objectKey, minio.GetObjectOptions{
 		VersionID: versionID,
 	})
 	if err != nil {
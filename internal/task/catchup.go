@@ -0,0 +1,123 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/history"
+	"github.com/korbiniankuhn/auto-restic/internal/restic"
+	"github.com/korbiniankuhn/auto-restic/internal/s3"
+	"github.com/robfig/cron/v3"
+)
+
+// Same cron format as the scheduler jobs
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+type MissedJob struct {
+	Name        string
+	LastSuccess time.Time
+	MissedSlot  time.Time
+}
+
+// MissedJobs returns the scheduled jobs whose last successful run is older than
+// their latest cron slot, e.g. because the server was down at that time
+func MissedJobs(ctx context.Context, c config.Config, repos restic.Repositories, s *s3.S3, h *history.Store, now time.Time) ([]MissedJob, error) {
+	snapshots, err := repos.ListLatestSnapshots(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list latest snapshots: %w", err)
+	}
+	// Only snapshots in the repository of a backup count for it
+	type snapshotKey struct{ repository, name string }
+	latestSnapshots := map[snapshotKey]time.Time{}
+	for _, snapshot := range snapshots {
+		key := snapshotKey{snapshot.Repository, snapshot.Name}
+		if snapshot.Time.After(latestSnapshots[key]) {
+			latestSnapshots[key] = snapshot.Time
+		}
+	}
+
+	objects, err := s.ListObjects(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list s3 objects: %w", err)
+	}
+	latestObjects := map[string]time.Time{}
+	for _, object := range objects {
+		if object.CreatedAt.After(latestObjects[object.BackupName]) {
+			latestObjects[object.BackupName] = object.CreatedAt
+		}
+	}
+
+	missed := []MissedJob{}
+	add := func(name, schedule string, lastSuccess time.Time) error {
+		job, ok, err := isMissed(name, schedule, lastSuccess, now, c.CatchUp.MaxStaleness)
+		if err != nil {
+			return err
+		}
+		if ok {
+			missed = append(missed, job)
+		}
+		return nil
+	}
+
+	// Backups first, so the S3 jobs upload the new snapshots
+	for _, backup := range c.Backups {
+		if err := add("backup:"+backup.Name, backup.Cron, latestSnapshots[snapshotKey{backup.Repository, backup.Name}]); err != nil {
+			return nil, err
+		}
+	}
+	for _, backup := range c.Backups {
+		if err := add("s3:"+backup.Name, backup.S3Cron, latestObjects[backup.Name]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Check and prune leave no trace in the repository, their last success comes from the history
+	for _, job := range []struct{ name, schedule string }{{"check", c.Cron.Check}, {"prune", c.Cron.Prune}} {
+		runs, err := h.List(history.Filter{Job: job.name, Status: history.StatusSuccess, Limit: 1})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list history: %w", err)
+		}
+		var lastSuccess time.Time
+		if len(runs) > 0 {
+			lastSuccess = runs[0].StartedAt
+		}
+		if err := add(job.name, job.schedule, lastSuccess); err != nil {
+			return nil, err
+		}
+	}
+
+	return missed, nil
+}
+
+// isMissed reports whether a slot of schedule passed since lastSuccess. When the
+// latest missed slot is older than maxStaleness it is too late to catch it up and
+// the job waits for its next slot, 0 catches up every missed slot.
+func isMissed(name, schedule string, lastSuccess, now time.Time, maxStaleness time.Duration) (MissedJob, bool, error) {
+	job := MissedJob{Name: name, LastSuccess: lastSuccess}
+
+	s, err := cronParser.Parse(schedule)
+	if err != nil {
+		return job, false, fmt.Errorf("invalid cron expression %s of job %s: %w", schedule, name, err)
+	}
+
+	// Jobs that never succeeded, e.g. of a fresh install or a new backup, wait for their schedule
+	if lastSuccess.IsZero() {
+		return job, false, nil
+	}
+
+	job.MissedSlot = s.Next(lastSuccess)
+	if job.MissedSlot.IsZero() || job.MissedSlot.After(now) {
+		return job, false, nil
+	}
+
+	for next := s.Next(job.MissedSlot); !next.IsZero() && !next.After(now); next = s.Next(next) {
+		job.MissedSlot = next
+	}
+	if maxStaleness > 0 && now.Sub(job.MissedSlot) > maxStaleness {
+		return job, false, nil
+	}
+
+	return job, true, nil
+}
@@ -0,0 +1,73 @@
+package task
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsMissed(t *testing.T) {
+	now := time.Date(2024, 6, 12, 10, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		name         string
+		schedule     string
+		lastSuccess  time.Time
+		maxStaleness time.Duration
+		missed       bool
+		missedSlot   time.Time
+		err          bool
+	}{
+		{name: "never succeeded", schedule: "0 2 * * *"},
+		{name: "next slot in the future", schedule: "0 2 * * *", lastSuccess: now.Add(-8 * time.Hour)},
+		{
+			name:        "missed slot",
+			schedule:    "0 2 * * *",
+			lastSuccess: now.Add(-32 * time.Hour),
+			missed:      true,
+			missedSlot:  time.Date(2024, 6, 12, 2, 0, 0, 0, time.Local),
+		},
+		{
+			name:         "missed slot inside staleness",
+			schedule:     "0 2 * * *",
+			lastSuccess:  now.Add(-32 * time.Hour),
+			maxStaleness: 12 * time.Hour,
+			missed:       true,
+			missedSlot:   time.Date(2024, 6, 12, 2, 0, 0, 0, time.Local),
+		},
+		{
+			name:         "missed slot outside staleness",
+			schedule:     "0 2 * * *",
+			lastSuccess:  now.Add(-32 * time.Hour),
+			maxStaleness: 6 * time.Hour,
+			missedSlot:   time.Date(2024, 6, 12, 2, 0, 0, 0, time.Local),
+		},
+		{
+			// Only the latest missed slot counts for the staleness
+			name:         "hourly after a long downtime",
+			schedule:     "0 * * * *",
+			lastSuccess:  now.Add(-72 * time.Hour),
+			maxStaleness: 24 * time.Hour,
+			missed:       true,
+			missedSlot:   time.Date(2024, 6, 12, 10, 0, 0, 0, time.Local),
+		},
+		{name: "invalid cron", schedule: "every day", lastSuccess: now.Add(-32 * time.Hour), err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, missed, err := isMissed("backup:app", tt.schedule, tt.lastSuccess, now, tt.maxStaleness)
+			if (err != nil) != tt.err {
+				t.Fatalf("error = %v, want error %t", err, tt.err)
+			}
+			if missed != tt.missed {
+				t.Errorf("missed = %t, want %t", missed, tt.missed)
+			}
+			if !tt.missedSlot.IsZero() && !job.MissedSlot.Equal(tt.missedSlot) {
+				t.Errorf("missed slot = %s, want %s", job.MissedSlot, tt.missedSlot)
+			}
+			if job.Name != "backup:app" || !job.LastSuccess.Equal(tt.lastSuccess) {
+				t.Errorf("job = %+v, want backup:app with last success %s", job, tt.lastSuccess)
+			}
+		})
+	}
+}
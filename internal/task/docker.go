@@ -0,0 +1,105 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/docker"
+	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+)
+
+// Time to start stopped and unpause paused containers, independent of the backup timeout
+const containerResumeTimeout = 2 * time.Minute
+
+// DiscoverBackups merges the backups of labelled containers into the config. The discovered
+// backups are also returned on their own, since they have no jobs of their own.
+func DiscoverBackups(ctx context.Context, c config.Config, m *metrics.Metrics) (config.Config, []config.BackupConfig) {
+	if !c.Docker.Discovery {
+		return c, nil
+	}
+
+	discovered, invalid, err := docker.New(c.Docker.Socket).DiscoverBackups(ctx, c)
+	if err != nil {
+		slog.Error("failed to discover backups from docker labels", "error", err)
+		m.AddSchedulerError(metrics.SchedulerErrorDockerDiscovery)
+		return c, nil
+	}
+	// One container with invalid labels must not stop the backups of the others
+	for _, err := range invalid {
+		slog.Warn("skip container with invalid backup labels", "error", err)
+		m.AddSchedulerError(metrics.SchedulerErrorDockerDiscovery)
+	}
+	for _, backup := range discovered {
+		slog.Debug("discovered backup", "backup", backup.Name, "paths", backup.Paths)
+	}
+
+	c.Backups = slices.Concat(c.Backups, discovered)
+	return c, discovered
+}
+
+// suspendContainers stops and pauses the running containers of a backup. resume starts and
+// unpauses them again, it must always be called, also if suspending failed, and only acts once.
+func suspendContainers(ctx context.Context, d *docker.Client, stop, pause []string) (resume func() error, err error) {
+	stopped := []string{}
+	paused := []string{}
+	resume = func() error {
+		// The backup context may already be cancelled, the containers have to come back anyway
+		ctx, cancel := context.WithTimeout(context.Background(), containerResumeTimeout)
+		defer cancel()
+
+		errs := []error{}
+		for _, name := range slices.Backward(paused) {
+			slog.Info("unpause container", "container", name)
+			if err := d.UnpauseContainer(ctx, name); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		for _, name := range slices.Backward(stopped) {
+			slog.Info("start container", "container", name)
+			if err := d.StartContainer(ctx, name); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		stopped, paused = nil, nil
+		return errors.Join(errs...)
+	}
+
+	for _, name := range stop {
+		container, err := d.InspectContainer(ctx, name)
+		if err != nil {
+			return resume, err
+		}
+		// Containers that are not running are left alone and not started afterwards
+		if container.State != "running" {
+			continue
+		}
+
+		slog.Info("stop container", "container", name)
+		if err := d.StopContainer(ctx, name); err != nil {
+			return resume, err
+		}
+		stopped = append(stopped, name)
+	}
+
+	for _, name := range pause {
+		container, err := d.InspectContainer(ctx, name)
+		if err != nil {
+			return resume, err
+		}
+		if container.State != "running" {
+			continue
+		}
+
+		slog.Info("pause container", "container", name)
+		if err := d.PauseContainer(ctx, name); err != nil {
+			return resume, err
+		}
+		paused = append(paused, name)
+	}
+
+	return resume, nil
+}
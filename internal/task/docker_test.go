@@ -0,0 +1,225 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/docker"
+	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+	"github.com/korbiniankuhn/auto-restic/internal/restic"
+)
+
+// fakeDocker is a Docker API on a unix socket that keeps the state of its containers
+type fakeDocker struct {
+	mu      sync.Mutex
+	states  map[string]string
+	fail    map[string]bool
+	actions []string
+}
+
+func newFakeDocker(t *testing.T, states map[string]string, fail ...string) (*fakeDocker, *docker.Client) {
+	t.Helper()
+	f := &fakeDocker{states: states, fail: map[string]bool{}}
+	for _, action := range fail {
+		f.fail[action] = true
+	}
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on socket: %v", err)
+	}
+	server := httptest.NewUnstartedServer(f)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return f, docker.New(socket)
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/containers/"), "/")
+	name, action := parts[0], parts[1]
+	state, ok := f.states[name]
+	if !ok {
+		http.Error(w, "No such container", http.StatusNotFound)
+		return
+	}
+	if action == "json" {
+		json.NewEncoder(w).Encode(map[string]any{"Id": name, "Name": "/" + name, "State": map[string]string{"Status": state}})
+		return
+	}
+
+	f.actions = append(f.actions, action+" "+name)
+	if f.fail[action+" "+name] {
+		http.Error(w, "cannot "+action, http.StatusInternalServerError)
+		return
+	}
+	f.states[name] = map[string]string{"stop": "exited", "start": "running", "pause": "paused", "unpause": "running"}[action]
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeDocker) result() ([]string, map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.actions), f.states
+}
+
+func TestSuspendContainers(t *testing.T) {
+	f, d := newFakeDocker(t, map[string]string{"app": "running", "worker": "running", "db": "running", "cache": "running", "old": "exited"})
+
+	resume, err := suspendContainers(context.Background(), d, []string{"app", "old", "worker"}, []string{"db", "cache"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := resume(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// resume only acts once
+	if err := resume(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Paused containers come back first, both in reverse order, stopped ones are left alone
+	actions, states := f.result()
+	want := []string{"stop app", "stop worker", "pause db", "pause cache", "unpause cache", "unpause db", "start worker", "start app"}
+	if !slices.Equal(actions, want) {
+		t.Errorf("actions = %q, want %q", actions, want)
+	}
+	if states["old"] != "exited" {
+		t.Errorf("old is %s, want it to stay exited", states["old"])
+	}
+}
+
+func TestSuspendContainersResumesAfterFailure(t *testing.T) {
+	tests := []struct {
+		name  string
+		fail  string
+		stop  []string
+		pause []string
+		want  []string
+	}{
+		{
+			name: "stop fails",
+			fail: "stop worker",
+			stop: []string{"app", "worker"},
+			want: []string{"stop app", "stop worker", "start app"},
+		},
+		{
+			name:  "pause fails",
+			fail:  "pause cache",
+			stop:  []string{"app"},
+			pause: []string{"db", "cache"},
+			want:  []string{"stop app", "pause db", "pause cache", "unpause db", "start app"},
+		},
+		{
+			name:  "unknown container",
+			stop:  []string{"app"},
+			pause: []string{"missing"},
+			want:  []string{"stop app", "start app"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, d := newFakeDocker(t, map[string]string{"app": "running", "worker": "running", "db": "running", "cache": "running"}, tt.fail)
+
+			resume, err := suspendContainers(context.Background(), d, tt.stop, tt.pause)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if err := resume(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actions, _ := f.result(); !slices.Equal(actions, tt.want) {
+				t.Errorf("actions = %q, want %q", actions, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateSnapshotResumesContainers(t *testing.T) {
+	// restic fails the backup or hangs until it is cancelled
+	dir := t.TempDir()
+	writeStub(t, dir, "restic", `#!/bin/sh
+[ "$1" = "backup" ] || exit 0
+[ -n "$HANG" ] && exec sleep 10
+echo "Fatal: unable to open repository" >&2
+exit 1
+`)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r, err := restic.NewRestic(context.Background(), "default", restic.Options{Repository: filepath.Join(dir, "repo"), Password: "x"})
+	if err != nil {
+		t.Fatalf("failed to create restic: %v", err)
+	}
+	backup := config.BackupConfig{Name: "app", Paths: []string{dir}, StopContainers: []string{"app"}, PauseContainers: []string{"db"}}
+	want := []string{"stop app", "pause db", "unpause db", "start app"}
+
+	t.Run("failed backup", func(t *testing.T) {
+		f, d := newFakeDocker(t, map[string]string{"app": "running", "db": "running"})
+		_, err := createSnapshot(context.Background(), r, d, backup, nil)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if actions, _ := f.result(); !slices.Equal(actions, want) {
+			t.Errorf("actions = %q, want %q", actions, want)
+		}
+	})
+
+	t.Run("cancelled backup", func(t *testing.T) {
+		t.Setenv("HANG", "1")
+		f, d := newFakeDocker(t, map[string]string{"app": "running", "db": "running"})
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		_, err := createSnapshot(ctx, r, d, backup, nil)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if actions, states := f.result(); !slices.Equal(actions, want) || states["app"] != "running" || states["db"] != "running" {
+			t.Errorf("actions = %q and states %v, want %q", actions, states, want)
+		}
+	})
+}
+
+func TestBackupDirectoryResumesContainersBetweenAttempts(t *testing.T) {
+	dir := t.TempDir()
+	writeStub(t, dir, "restic", `#!/bin/sh
+[ "$1" = "backup" ] || exit 0
+echo "Fatal: unable to open repository" >&2
+exit 1
+`)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r, err := restic.NewRestic(context.Background(), "default", restic.Options{Repository: filepath.Join(dir, "repo"), Password: "x"})
+	if err != nil {
+		t.Fatalf("failed to create restic: %v", err)
+	}
+	f, d := newFakeDocker(t, map[string]string{"app": "running"})
+	backup := config.BackupConfig{Name: "app", Paths: []string{dir}, StopContainers: []string{"app"}}
+	policy := config.RetryConfig{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	_, err = backupDirectory(context.Background(), policy, metrics.NewMetrics(), r, d, backup, nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// The container does not stay down while waiting for the next attempt
+	want := []string{"stop app", "start app", "stop app", "start app"}
+	if actions, _ := f.result(); !slices.Equal(actions, want) {
+		t.Errorf("actions = %q, want %q", actions, want)
+	}
+}
@@ -0,0 +1,273 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+	"github.com/korbiniankuhn/auto-restic/internal/notify"
+	"github.com/korbiniankuhn/auto-restic/internal/restic"
+	"github.com/korbiniankuhn/auto-restic/internal/utils"
+)
+
+// Timeout of post_failure and finally hooks without their own timeout, they also run after
+// the backup was cancelled
+const cleanupHookTimeout = 5 * time.Minute
+
+type hookStatus string
+
+const (
+	hookStatusRunning   hookStatus = "running"
+	hookStatusSucceeded hookStatus = "succeeded"
+	hookStatusPartial   hookStatus = "partial"
+	hookStatusFailed    hookStatus = "failed"
+)
+
+func hookEnv(backup config.BackupConfig, summary restic.BackupSummary, status hookStatus, err error) []string {
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	return append(os.Environ(),
+		"AUTO_RESTIC_BACKUP_NAME="+backup.Name,
+		"AUTO_RESTIC_SNAPSHOT_ID="+summary.SnapshotID,
+		"AUTO_RESTIC_STATUS="+string(status),
+		"AUTO_RESTIC_ERROR="+message,
+	)
+}
+
+func jobHookEnv(job notify.Job, status hookStatus, err error) []string {
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	return append(os.Environ(),
+		"AUTO_RESTIC_JOB="+string(job),
+		"AUTO_RESTIC_STATUS="+string(status),
+		"AUTO_RESTIC_ERROR="+message,
+	)
+}
+
+func getJobHooks(c config.Config, job notify.Job) config.JobHookConfig {
+	switch job {
+	case notify.JobBackup:
+		return c.JobHooks.Backup
+	case notify.JobS3:
+		return c.JobHooks.S3
+	case notify.JobCheck:
+		return c.JobHooks.Check
+	case notify.JobPrune:
+		return c.JobHooks.Prune
+	default:
+		return config.JobHookConfig{}
+	}
+}
+
+// jobGroup are the jobs of one type that run at the same time, they share one run of the job hooks
+type jobGroup struct {
+	active int
+	errs   []error
+	// ready is closed when the pre hooks finished with preErr
+	ready  chan struct{}
+	preErr error
+	// done is closed when the post hooks finished, the next job of the type waits for it
+	done chan struct{}
+}
+
+var (
+	jobGroupsMu sync.Mutex
+	jobGroups   = map[notify.Job]*jobGroup{}
+)
+
+// joinJobGroup adds a job to the running group of its type. first is true if the job starts a new
+// group and has to run the pre hooks.
+func joinJobGroup(ctx context.Context, job notify.Job) (group *jobGroup, first bool, err error) {
+	for {
+		jobGroupsMu.Lock()
+		group, ok := jobGroups[job]
+		if !ok {
+			group = &jobGroup{active: 1, ready: make(chan struct{}), done: make(chan struct{})}
+			jobGroups[job] = group
+			jobGroupsMu.Unlock()
+			return group, true, nil
+		}
+		if group.active > 0 {
+			group.active++
+			jobGroupsMu.Unlock()
+			return group, false, nil
+		}
+		jobGroupsMu.Unlock()
+
+		// The last job of the group is running the post hooks
+		select {
+		case <-group.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+}
+
+// startJobHooks runs the pre hooks of a job, an error means the job has to be aborted. Jobs of the
+// same type that overlap share the hooks: pre runs when the first of them starts and post when the
+// last of them finishes. finish runs the post hooks with the result of the jobs and must always be
+// called, it returns the result of the job including failed post hooks.
+func startJobHooks(ctx context.Context, c config.Config, m *metrics.Metrics, job notify.Job) (finish func(error) error, err error) {
+	hooks := getJobHooks(c, job)
+	abort := hooks.OnFailure != config.HookFailureContinue
+
+	group, first, err := joinJobGroup(ctx, job)
+	if err != nil {
+		return func(jobErr error) error { return jobErr }, fmt.Errorf("failed to wait for post job hooks: %w", err)
+	}
+
+	finish = func(jobErr error) error {
+		jobGroupsMu.Lock()
+		group.active--
+		group.errs = append(group.errs, jobErr)
+		last := group.active == 0
+		groupErr := errors.Join(group.errs...)
+		jobGroupsMu.Unlock()
+		if !last {
+			return jobErr
+		}
+		defer func() {
+			jobGroupsMu.Lock()
+			delete(jobGroups, job)
+			jobGroupsMu.Unlock()
+			close(group.done)
+		}()
+
+		status := hookStatusSucceeded
+		if groupErr != nil {
+			status = hookStatusFailed
+		}
+		err := runCleanupHooks(ctx, m, job, "", "post", hooks.Post, jobHookEnv(job, status, groupErr))
+		if err == nil {
+			return jobErr
+		}
+		if !abort {
+			slog.Warn("post job hook failed, continuing", "job", job, "error", err)
+			return jobErr
+		}
+		m.AddJobError(string(job), "", metrics.ErrorKindHookFailed)
+		slog.Error("post job hook failed", "job", job, "error", err)
+		return errors.Join(jobErr, err)
+	}
+
+	if !first {
+		select {
+		case <-group.ready:
+			return finish, group.preErr
+		case <-ctx.Done():
+			return finish, fmt.Errorf("failed to wait for pre job hooks: %w", ctx.Err())
+		}
+	}
+
+	defer close(group.ready)
+	err = runHooks(ctx, m, job, "", "pre", hooks.Pre, jobHookEnv(job, hookStatusRunning, nil))
+	if err == nil {
+		return finish, nil
+	}
+	if !abort {
+		slog.Warn("pre job hook failed, continuing", "job", job, "error", err)
+		return finish, nil
+	}
+	m.AddJobError(string(job), "", metrics.ErrorKindHookFailed)
+	slog.Error("pre job hook failed, aborting job", "job", job, "error", err)
+	group.preErr = err
+	return finish, err
+}
+
+// runHooks runs the hooks of a stage one after another and stops at the first failure
+func runHooks(ctx context.Context, m *metrics.Metrics, job notify.Job, backup string, stage string, hooks []config.HookConfig, env []string) error {
+	for _, hook := range hooks {
+		err := runHook(ctx, job, backup, stage, hook, env)
+		m.AddHookRun(string(job), backup, stage, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCleanupHooks runs all hooks of a stage, even if the context is already cancelled
+func runCleanupHooks(ctx context.Context, m *metrics.Metrics, job notify.Job, backup string, stage string, hooks []config.HookConfig, env []string) error {
+	errs := []error{}
+	for _, hook := range hooks {
+		if hook.Timeout == 0 {
+			hook.Timeout = cleanupHookTimeout
+		}
+		err := runHook(context.WithoutCancel(ctx), job, backup, stage, hook, env)
+		m.AddHookRun(string(job), backup, stage, err)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func runHook(ctx context.Context, job notify.Job, backup string, stage string, hook config.HookConfig, env []string) error {
+	hookCtx, cancel := withTimeout(ctx, hook.Timeout)
+	defer cancel()
+
+	attrs := []any{"job", job, "backup", backup, "hook", stage}
+	slog.Info("run hook", append(attrs, "command", hook.Command)...)
+	stdout := &logWriter{level: slog.LevelInfo, attrs: append(attrs, "stream", "stdout")}
+	stderr := &logWriter{level: slog.LevelWarn, attrs: append(attrs, "stream", "stderr")}
+	cmd := exec.CommandContext(hookCtx, "sh", "-c", hook.Command)
+	cmd.Dir = hook.WorkingDir
+	cmd.Env = env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Background processes of the hook may keep the output open
+	cmd.WaitDelay = 10 * time.Second
+
+	err := cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to run %s hook %s: %w", stage, hook.Command, utils.ContextError(hookCtx, err))
+	}
+	return nil
+}
+
+// logWriter logs every line written to it
+type logWriter struct {
+	level slog.Level
+	attrs []any
+	buf   []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.log(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *logWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.log(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *logWriter) log(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	slog.Log(context.Background(), w.level, "hook output", slices.Concat(w.attrs, []any{"line", string(line)})...)
+}
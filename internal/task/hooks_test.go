@@ -0,0 +1,194 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/docker"
+	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+	"github.com/korbiniankuhn/auto-restic/internal/notify"
+	"github.com/korbiniankuhn/auto-restic/internal/restic"
+)
+
+func jobHooksConfig(file string) config.Config {
+	command := func(stage string) []config.HookConfig {
+		return []config.HookConfig{{Command: "echo " + stage + " $AUTO_RESTIC_STATUS >> " + file}}
+	}
+	c := config.Config{}
+	c.JobHooks.Backup = config.JobHookConfig{Pre: command("pre"), Post: command("post")}
+	return c
+}
+
+func readHookLog(t *testing.T, file string) []string {
+	t.Helper()
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read hook log: %v", err)
+	}
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func TestJobHooksRunOnceForOverlappingJobs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hooks.log")
+	c := jobHooksConfig(file)
+	m := metrics.NewMetrics()
+	ctx := context.Background()
+
+	finishFirst, err := startJobHooks(ctx, c, m, notify.JobBackup)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	finishSecond, err := startJobHooks(ctx, c, m, notify.JobBackup)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The post hooks wait for the second job, which is still running
+	failed := errors.New("backup failed")
+	if err := finishFirst(failed); !errors.Is(err, failed) {
+		t.Errorf("finish = %v, want %v", err, failed)
+	}
+	if got := readHookLog(t, file); len(got) != 1 || got[0] != "pre running" {
+		t.Fatalf("hooks = %q, want only the pre hook", got)
+	}
+	if err := finishSecond(nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got := readHookLog(t, file); len(got) != 2 || got[1] != "post failed" {
+		t.Fatalf("hooks = %q, want one failed post hook", got)
+	}
+
+	// A later job starts a new run of the hooks
+	finish, err := startJobHooks(ctx, c, m, notify.JobBackup)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := finish(nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := []string{"pre running", "post failed", "pre running", "post succeeded"}
+	if got := readHookLog(t, file); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("hooks = %q, want %q", got, want)
+	}
+}
+
+func TestJobHooksPreFailureAbortsOverlappingJobs(t *testing.T) {
+	c := config.Config{}
+	c.JobHooks.Backup = config.JobHookConfig{Pre: []config.HookConfig{{Command: "exit 1"}}}
+	m := metrics.NewMetrics()
+	ctx := context.Background()
+
+	finishFirst, err := startJobHooks(ctx, c, m, notify.JobBackup)
+	if err == nil {
+		t.Fatal("expected an error for a failing pre hook")
+	}
+	finishSecond, err := startJobHooks(ctx, c, m, notify.JobBackup)
+	if err == nil {
+		t.Fatal("expected the pre hook error for an overlapping job")
+	}
+	finishFirst(err)
+	finishSecond(err)
+}
+
+func TestBackupDirectoryHooks(t *testing.T) {
+	dir := t.TempDir()
+	writeStub(t, dir, "restic", `#!/bin/sh
+[ "$1" = "backup" ] || exit 0
+touch "$BACKUP_RAN"
+[ "${BACKUP_EXIT:-0}" = 1 ] && { echo "Fatal: unable to save snapshot: connection reset" >&2; exit 1; }
+echo '{"message_type":"summary","snapshot_id":"abc"}'
+exit "${BACKUP_EXIT:-0}"
+`)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r, err := restic.NewRestic(context.Background(), "default", restic.Options{Repository: filepath.Join(dir, "repo"), Password: "x"})
+	if err != nil {
+		t.Fatalf("failed to create restic: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		preFail bool
+		exit    string
+		backup  bool
+		// stage, status and snapshot ID (- if empty) of the hooks that ran
+		want []string
+		err  string
+	}{
+		{
+			name:   "success",
+			backup: true,
+			want:   []string{"pre running -", "post_success succeeded abc", "finally succeeded abc"},
+		},
+		{
+			name:   "partial backup",
+			exit:   "3",
+			backup: true,
+			want:   []string{"pre running -", "post_success partial abc", "finally partial abc"},
+			err:    "partial",
+		},
+		{
+			name:   "backup fails",
+			exit:   "1",
+			backup: true,
+			want:   []string{"pre running -", "post_failure failed -", "finally failed -"},
+			err:    "connection reset",
+		},
+		{
+			name:    "pre hook fails",
+			preFail: true,
+			want:    []string{"pre running -", "post_failure failed -", "finally failed -"},
+			err:     "failed to run pre hook",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			file := filepath.Join(tmp, "hooks.log")
+			ran := filepath.Join(tmp, "backup-ran")
+			t.Setenv("BACKUP_RAN", ran)
+			t.Setenv("BACKUP_EXIT", tt.exit)
+
+			hook := func(stage string) []config.HookConfig {
+				return []config.HookConfig{{Command: `echo "` + stage + ` $AUTO_RESTIC_STATUS ${AUTO_RESTIC_SNAPSHOT_ID:--}" >> ` + file + `; echo "$AUTO_RESTIC_BACKUP_NAME:$AUTO_RESTIC_ERROR" > ` + file + `.` + stage}}
+			}
+			backup := config.BackupConfig{Name: "app", Paths: []string{dir}, Hooks: config.HooksConfig{
+				Pre:         hook("pre"),
+				PostSuccess: hook("post_success"),
+				PostFailure: hook("post_failure"),
+				Finally:     hook("finally"),
+			}}
+			if tt.preFail {
+				backup.Hooks.Pre = append(backup.Hooks.Pre, config.HookConfig{Command: "exit 1"})
+			}
+
+			_, err := backupDirectory(context.Background(), config.RetryConfig{MaxAttempts: 1}, metrics.NewMetrics(), r, docker.New(""), backup, nil)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error = %v, want %q", err, tt.err)
+			}
+			if _, statErr := os.Stat(ran); (statErr == nil) != tt.backup {
+				t.Errorf("backup ran = %t, want %t", statErr == nil, tt.backup)
+			}
+			if got := readHookLog(t, file); !slices.Equal(got, tt.want) {
+				t.Errorf("hooks = %q, want %q", got, tt.want)
+			}
+
+			// The error of the backup is passed to the hooks, but not to the successful ones
+			finally, err := os.ReadFile(file + ".finally")
+			if err != nil {
+				t.Fatalf("finally hook did not run: %v", err)
+			}
+			name, message, _ := strings.Cut(strings.TrimSpace(string(finally)), ":")
+			if name != "app" || tt.err == "" && message != "" || tt.err != "" && !strings.Contains(message, tt.err) {
+				t.Errorf("finally hook got backup %q and error %q, want app and %q", name, message, tt.err)
+			}
+		})
+	}
+}
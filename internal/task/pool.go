@@ -0,0 +1,35 @@
+package task
+
+import (
+	"sync"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+)
+
+// forEachBackup runs fn for the backups with at most workers at the same time and returns the
+// errors in the order of the backups
+func forEachBackup(backups []config.BackupConfig, workers int, fn func(config.BackupConfig) error) []error {
+	results := make([]error, len(backups))
+	sem := make(chan struct{}, max(workers, 1))
+	wg := sync.WaitGroup{}
+	for i, backup := range backups {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = fn(backup)
+		}()
+	}
+	wg.Wait()
+
+	errs := []error{}
+	for _, err := range results {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,59 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+	"github.com/korbiniankuhn/auto-restic/internal/notify"
+	"github.com/korbiniankuhn/auto-restic/internal/restic"
+)
+
+// retry runs fn until it succeeds, the attempts of the policy are exhausted or ctx is done
+// and returns the error of the last attempt
+func retry(ctx context.Context, policy config.RetryConfig, m *metrics.Metrics, job notify.Job, name string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		m.AddJobAttempt(string(job), name, err)
+		if err == nil || attempt >= policy.MaxAttempts || ctx.Err() != nil || !isRetryable(err) {
+			return err
+		}
+
+		delay := retryDelay(policy, attempt)
+		slog.Warn("attempt failed, retrying", "job", job, "backup", name, "attempt", attempt, "max_attempts", policy.MaxAttempts, "delay", delay, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryable reports whether the error may be transient, e.g. a lock held by the CLI or a network error
+func isRetryable(err error) bool {
+	return !errors.Is(err, restic.ErrWrongPassword) &&
+		!errors.Is(err, restic.ErrRepoNotFound) &&
+		!errors.Is(err, restic.ErrSourceUnreadable) &&
+		!errors.Is(err, restic.ErrPartialBackup)
+}
+
+// retryDelay doubles the base delay with every attempt up to the max delay and applies the jitter
+func retryDelay(policy config.RetryConfig, attempt int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, policy.MaxDelay)
+
+	if policy.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + policy.Jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
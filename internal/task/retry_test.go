@@ -0,0 +1,122 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+	"github.com/korbiniankuhn/auto-restic/internal/notify"
+	"github.com/korbiniankuhn/auto-restic/internal/restic"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := config.RetryConfig{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: 2 * time.Second},
+		{attempt: 3, want: 4 * time.Second},
+		{attempt: 4, want: 8 * time.Second},
+		{attempt: 5, want: 10 * time.Second},
+		{attempt: 100, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			if got := retryDelay(policy, tt.attempt); got != tt.want {
+				t.Errorf("delay = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	policy := config.RetryConfig{BaseDelay: 10 * time.Second, MaxDelay: time.Minute, Jitter: 0.2}
+	for range 100 {
+		if got := retryDelay(policy, 1); got < 8*time.Second || got > 12*time.Second {
+			t.Fatalf("delay = %s, want within 20%% of 10s", got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := config.RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	transient := &restic.Error{Kind: restic.ErrCommandFailed, Message: "connection reset"}
+
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		err      error
+	}{
+		{name: "success", errs: []error{nil}, attempts: 1},
+		{name: "success after retry", errs: []error{transient, nil}, attempts: 2},
+		{name: "attempts exhausted", errs: []error{transient, transient, transient}, attempts: 3, err: restic.ErrCommandFailed},
+		{name: "locked is retried", errs: []error{&restic.Error{Kind: restic.ErrRepositoryLocked}, nil}, attempts: 2},
+		{name: "wrong password", errs: []error{&restic.Error{Kind: restic.ErrWrongPassword}}, attempts: 1, err: restic.ErrWrongPassword},
+		{name: "repository not found", errs: []error{&restic.Error{Kind: restic.ErrRepoNotFound}}, attempts: 1, err: restic.ErrRepoNotFound},
+		{name: "unreadable source", errs: []error{&restic.Error{Kind: restic.ErrSourceUnreadable}}, attempts: 1, err: restic.ErrSourceUnreadable},
+		{name: "partial backup", errs: []error{fmt.Errorf("partial backup of /data: %w", &restic.Error{Kind: restic.ErrPartialBackup})}, attempts: 1, err: restic.ErrPartialBackup},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := retry(context.Background(), policy, metrics.NewMetrics(), notify.JobBackup, "app", func() error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			})
+			if attempts != tt.attempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.attempts)
+			}
+			if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRetryCancelledDuringBackoff(t *testing.T) {
+	policy := config.RetryConfig{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	attempts := 0
+	failed := errors.New("network error")
+	started := time.Now()
+	err := retry(ctx, policy, metrics.NewMetrics(), notify.JobBackup, "app", func() error {
+		attempts++
+		return failed
+	})
+
+	// The error of the last attempt is returned without waiting for the backoff
+	if !errors.Is(err, failed) || attempts != 1 {
+		t.Errorf("error = %v after %d attempts, want %v after 1", err, attempts, failed)
+	}
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("retry waited %s after the context was cancelled", elapsed)
+	}
+}
+
+func TestRetryNotAfterCancel(t *testing.T) {
+	policy := config.RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	attempts := 0
+	retry(ctx, policy, metrics.NewMetrics(), notify.JobBackup, "app", func() error {
+		attempts++
+		cancel()
+		return context.Canceled
+	})
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
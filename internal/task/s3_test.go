@@ -0,0 +1,134 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/restic"
+)
+
+// uploadFunc is a streamUploader for tests
+type uploadFunc func(filename string, reader *io.PipeReader) error
+
+func (f uploadFunc) StreamUploadFile(ctx context.Context, filename string, reader *io.PipeReader) error {
+	return f(filename, reader)
+}
+
+// newDumpRestic returns a repository whose restic dump runs the shell script dump
+func newDumpRestic(t *testing.T, dump string) restic.Restic {
+	t.Helper()
+	dir := t.TempDir()
+	writeStub(t, dir, "restic", "#!/bin/sh\n[ \"$1\" = \"dump\" ] || exit 0\n"+dump)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	r, err := restic.NewRestic(context.Background(), "default", restic.Options{Repository: filepath.Join(dir, "repo"), Password: "x"})
+	if err != nil {
+		t.Fatalf("failed to create restic: %v", err)
+	}
+	return r
+}
+
+// waitForGoroutines waits until no more than n goroutines are running
+func waitForGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines are still running, want %d:\n%s", runtime.NumGoroutine(), n, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCreateAndUploadEncryptedDump(t *testing.T) {
+	r := newDumpRestic(t, "printf 'archive'\n")
+	snapshot := restic.Snapshot{Name: "app"}
+	snapshot.ID = "abc"
+
+	var filename string
+	var uploaded int64
+	upload := uploadFunc(func(name string, reader *io.PipeReader) error {
+		filename = name
+		n, err := io.Copy(io.Discard, reader)
+		uploaded = n
+		return err
+	})
+
+	size, err := createAndUploadEncryptedDump(context.Background(), r, upload, snapshot, "secret", config.S3DumpModeStream, func(archived, uploaded int64) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filename != "app.tar.gz.age" {
+		t.Errorf("filename = %s, want app.tar.gz.age", filename)
+	}
+	if size == 0 || size != uploaded {
+		t.Errorf("size = %d, want the %d uploaded bytes", size, uploaded)
+	}
+}
+
+func TestCreateAndUploadEncryptedDumpArchiveError(t *testing.T) {
+	r := newDumpRestic(t, "printf 'partial archive'\necho 'Fatal: failed to load snapshot: pack not found' >&2\nexit 1\n")
+	snapshot := restic.Snapshot{Name: "app"}
+	snapshot.ID = "abc"
+
+	// The upload sees the archive error instead of a complete archive
+	var readErr error
+	upload := uploadFunc(func(name string, reader *io.PipeReader) error {
+		_, readErr = io.Copy(io.Discard, reader)
+		return readErr
+	})
+
+	_, err := createAndUploadEncryptedDump(context.Background(), r, upload, snapshot, "secret", config.S3DumpModeStream, func(archived, uploaded int64) {})
+	if err == nil || !strings.Contains(err.Error(), "failed during archive creation") || !strings.Contains(err.Error(), "pack not found") {
+		t.Errorf("error = %v, want the archive error", err)
+	}
+	if !errors.Is(err, restic.ErrCommandFailed) {
+		t.Errorf("error = %v, want %v", err, restic.ErrCommandFailed)
+	}
+	if readErr == nil || !strings.Contains(readErr.Error(), "pack not found") {
+		t.Errorf("upload read error = %v, want the archive error", readErr)
+	}
+}
+
+func TestCreateAndUploadEncryptedDumpUploadError(t *testing.T) {
+	// The dump is larger than the pipe buffers, so restic blocks once the upload stops reading
+	r := newDumpRestic(t, "head -c 20000000 /dev/urandom\n")
+	snapshot := restic.Snapshot{Name: "app"}
+	snapshot.ID = "abc"
+	before := runtime.NumGoroutine()
+
+	failed := errors.New("connection reset by peer")
+	upload := uploadFunc(func(name string, reader *io.PipeReader) error {
+		// Fail in the middle of the archive
+		if _, err := io.CopyN(io.Discard, reader, 1<<20); err != nil {
+			return err
+		}
+		return failed
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := createAndUploadEncryptedDump(context.Background(), r, upload, snapshot, "secret", config.S3DumpModeStream, func(archived, uploaded int64) {})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, failed) || !strings.Contains(err.Error(), "failed to upload to s3") {
+			t.Errorf("error = %v, want the failed upload", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("dump did not stop after the upload failed")
+	}
+	// Neither the archive writer, the progress reporter nor restic are left behind
+	waitForGoroutines(t, before)
+}
@@ -2,97 +2,406 @@ package task
 
 import (
 	"archive/tar"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
-	"os/exec"
+	"sort"
+	"strings"
 	"time"
 
 	"filippo.io/age"
 	"github.com/klauspost/pgzip"
 	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/database"
+	"github.com/korbiniankuhn/auto-restic/internal/docker"
+	"github.com/korbiniankuhn/auto-restic/internal/history"
 	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+	"github.com/korbiniankuhn/auto-restic/internal/notify"
+	"github.com/korbiniankuhn/auto-restic/internal/ping"
+	"github.com/korbiniankuhn/auto-restic/internal/progress"
 	"github.com/korbiniankuhn/auto-restic/internal/restic"
 	"github.com/korbiniankuhn/auto-restic/internal/s3"
 	"github.com/korbiniankuhn/auto-restic/internal/utils"
 )
 
-func ResticCheck(m *metrics.Metrics, r restic.Restic) {
+func ResticCheck(ctx context.Context, c config.Config, m *metrics.Metrics, n *notify.Dispatcher, h *history.Store, repos restic.Repositories) {
 	slog.Info("run restic check")
-	err := r.Check()
-	if err != nil {
-		m.AddSchedulerError(metrics.SchedulerErrorResticCheck)
-		slog.Error("failed to check restic repository", "error", err)
-	} else {
-		slog.Info("restic check completed")
+	startedAt := time.Now()
+	ping.Start(c.Pings.Check)
+	publish(n, h, notify.NewStartedEvent(notify.JobCheck, ""))
+
+	jobCtx, cancel := withTimeout(ctx, c.Timeouts.Check)
+	defer cancel()
+
+	finishHooks, err := startJobHooks(jobCtx, c, m, notify.JobCheck)
+	if err == nil {
+		errs := []error{}
+		for _, r := range repos {
+			err := retry(jobCtx, c.Retries.Check, m, notify.JobCheck, "", func() error {
+				return r.Check(jobCtx)
+			})
+			if err != nil {
+				m.AddSchedulerError(metrics.SchedulerErrorResticCheck)
+				slog.Error("failed to check restic repository", "repository", r.Name(), "error", err)
+				errs = append(errs, err)
+			}
+		}
+		err = errors.Join(errs...)
+		if err != nil {
+			m.AddJobError(string(notify.JobCheck), "", getErrorKind(jobCtx, err))
+		} else {
+			slog.Info("restic check completed")
+		}
 	}
+	err = finishHooks(err)
+	publish(n, h, notify.NewFinishedEvent(notify.JobCheck, "", startedAt, err))
+	ping.Finish(c.Pings.Check, err)
 }
 
-func ForgetAndPrune(c config.Config, m *metrics.Metrics, r restic.Restic) {
+func ForgetAndPrune(ctx context.Context, c config.Config, m *metrics.Metrics, n *notify.Dispatcher, h *history.Store, repos restic.Repositories) {
 	slog.Info("run restic forget and prune")
-	err := r.ForgetAndPrune(c.Restic.KeepDaily, c.Restic.KeepWeekly, c.Restic.KeepMonthly)
+	startedAt := time.Now()
+	ping.Start(c.Pings.Prune)
+	publish(n, h, notify.NewStartedEvent(notify.JobPrune, ""))
+
+	jobCtx, cancel := withTimeout(ctx, c.Timeouts.Prune)
+	defer cancel()
+
+	finishHooks, err := startJobHooks(jobCtx, c, m, notify.JobPrune)
 	if err != nil {
-		m.AddSchedulerError(metrics.SchedulerErrorResticForgetAndPrune)
-		slog.Error("failed to forget and prune restic repository", "error", err)
+		err = finishHooks(err)
+		publish(n, h, notify.NewFinishedEvent(notify.JobPrune, "", startedAt, err))
+		ping.Finish(c.Pings.Prune, err)
+		return
+	}
+
+	errs := []error{}
+	for _, r := range repos {
+		errs = append(errs, forgetAndPruneRepository(jobCtx, c, m, r)...)
+	}
+	if len(errs) > 0 {
+		m.AddJobError(string(notify.JobPrune), "", getErrorKind(jobCtx, errors.Join(errs...)))
 	} else {
 		slog.Info("restic forget and prune completed")
 	}
+	err = finishHooks(errors.Join(errs...))
+	publish(n, h, notify.NewFinishedEvent(notify.JobPrune, "", startedAt, err))
+	ping.Finish(c.Pings.Prune, err)
 
-	err = updateResticMetrics(c, m, r)
+	err = updateResticMetrics(ctx, c, m, repos)
 	if err != nil {
 		slog.Error("failed to update restic metrics", "error", err)
 	}
 }
 
-func Backup(c config.Config, m *metrics.Metrics, r restic.Restic) {
-	slog.Info("starting restic backups")
+func forgetAndPruneRepository(ctx context.Context, c config.Config, m *metrics.Metrics, r restic.Restic) []error {
+	snapshots, err := r.ListSnapshots(ctx)
+	if err != nil {
+		m.AddSchedulerError(metrics.SchedulerErrorResticListSnapshots)
+		slog.Error("failed to list snapshots", "repository", r.Name(), "error", err)
+		return []error{err}
+	}
+
+	// Forget each backup name with its own retention policy, including
+	// snapshots of backups that were removed from the config
+	names := map[string]bool{}
+	for _, snapshot := range snapshots {
+		if snapshot.HasName() {
+			names[snapshot.Name] = true
+		}
+	}
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	errs := []error{}
+	for _, name := range sortedNames {
+		err := retry(ctx, c.Retries.Prune, m, notify.JobPrune, name, func() error {
+			return r.ForgetByName(ctx, name, getRetentionPolicy(c, r.Name(), name))
+		})
+		if err != nil {
+			m.AddSchedulerError(metrics.SchedulerErrorResticForgetAndPrune)
+			slog.Error("failed to forget old snapshots", "repository", r.Name(), "backup", name, "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	err = retry(ctx, c.Retries.Prune, m, notify.JobPrune, "", func() error {
+		return r.Prune(ctx)
+	})
+	if err != nil {
+		m.AddSchedulerError(metrics.SchedulerErrorResticForgetAndPrune)
+		slog.Error("failed to prune restic repository", "repository", r.Name(), "error", err)
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+func getRetentionPolicy(c config.Config, repository, name string) restic.RetentionPolicy {
 	for _, backup := range c.Backups {
-		startedAt := time.Now()
-		if backup.PreCommand != "" {
-			slog.Info("run pre backup command", "command", backup.PreCommand)
-			cmd := exec.Command("sh", "-c", backup.PreCommand)
-			err := cmd.Run()
-			if err != nil {
-				m.AddResticErrorByBackupName(backup.Name)
-				slog.Error("failed to run pre backup command", "command", backup.PreCommand, "error", err)
-				continue
+		if backup.Name == name && backup.Retention != nil {
+			return restic.RetentionPolicy{
+				KeepLast:    backup.Retention.KeepLast,
+				KeepHourly:  backup.Retention.KeepHourly,
+				KeepDaily:   backup.Retention.KeepDaily,
+				KeepWeekly:  backup.Retention.KeepWeekly,
+				KeepMonthly: backup.Retention.KeepMonthly,
+				KeepYearly:  backup.Retention.KeepYearly,
+				KeepWithin:  backup.Retention.KeepWithin,
 			}
 		}
+	}
 
-		slog.Info("create restic snapshot", "paths", backup.Path)
-		err := r.BackupDirectory(backup.Name, backup.Path, backup.Exclude, backup.ExcludeFile)
+	// Fall back to the policy of the repository
+	repo, _ := c.GetRepository(repository)
+	return restic.RetentionPolicy{
+		KeepDaily:   repo.KeepDaily,
+		KeepWeekly:  repo.KeepWeekly,
+		KeepMonthly: repo.KeepMonthly,
+	}
+}
+
+func Backup(ctx context.Context, c config.Config, m *metrics.Metrics, n *notify.Dispatcher, h *history.Store, p *progress.Tracker, repos restic.Repositories, backups []config.BackupConfig) {
+	slog.Info("starting restic backups")
+	ping.Start(c.Pings.Backup)
+
+	jobCtx, cancel := withTimeout(ctx, c.Timeouts.Backup)
+	defer cancel()
+
+	errs := []error{}
+	finishHooks, err := startJobHooks(jobCtx, c, m, notify.JobBackup)
+	if err != nil {
+		for _, backup := range backups {
+			publish(n, h, notify.NewFinishedEvent(notify.JobBackup, backup.Name, time.Now(), err))
+			ping.Finish(backup.Ping, err)
+		}
+		errs = append(errs, err)
+		backups = nil
+	}
+
+	d := docker.New(c.Docker.Socket)
+	errs = append(errs, forEachBackup(backups, c.Concurrency.Backups, func(backup config.BackupConfig) error {
+		startedAt := time.Now()
+		ping.Start(backup.Ping)
+		publish(n, h, notify.NewStartedEvent(notify.JobBackup, backup.Name))
+
+		r, err := repos.Get(backup.Repository)
 		if err != nil {
-			m.AddResticErrorByBackupName(backup.Name)
-			slog.Error("failed to backup directory", "directory", backup.Path, "error", err)
-			continue
+			m.AddJobError(string(notify.JobBackup), backup.Name, metrics.ErrorKindFailed)
+			slog.Error("failed to create restic snapshot", "backup", backup.Name, "error", err)
+			publish(n, h, notify.NewFinishedEvent(notify.JobBackup, backup.Name, startedAt, err))
+			ping.Finish(backup.Ping, err)
+			return err
 		}
 
-		if backup.PostCommand != "" {
-			slog.Info("run post backup command", "command", backup.PostCommand)
-			cmd := exec.Command("sh", "-c", backup.PostCommand)
-			err := cmd.Run()
-			if err != nil {
-				m.AddResticErrorByBackupName(backup.Name)
-				slog.Error("failed to run post backup command", "command", backup.PostCommand, "error", err)
-				continue
-			}
+		backupCtx, cancel := withTimeout(jobCtx, backup.Timeout)
+		defer cancel()
+		p.Start(string(notify.JobBackup), backup.Name)
+		summary, err := backupDirectory(backupCtx, c.Retries.Backup, m, r, d, backup, func(status restic.BackupStatus) {
+			p.Update(progress.Progress{
+				Job:              string(notify.JobBackup),
+				Backup:           backup.Name,
+				PercentDone:      status.PercentDone,
+				BytesDone:        status.BytesDone,
+				TotalBytes:       status.TotalBytes,
+				FilesDone:        status.FilesDone,
+				TotalFiles:       status.TotalFiles,
+				SecondsRemaining: status.SecondsRemaining,
+			})
+		})
+		p.Finish(string(notify.JobBackup), backup.Name)
+
+		// Some source files could not be read, but the snapshot was created
+		var partialErr error
+		if errors.Is(err, restic.ErrPartialBackup) {
+			partialErr, err = err, nil
+			m.AddJobError(string(notify.JobBackup), backup.Name, metrics.ErrorKindPartial)
+			slog.Warn("restic snapshot is incomplete", "backup", backup.Name, "error", partialErr)
+		}
+
+		event := notify.NewFinishedEvent(notify.JobBackup, backup.Name, startedAt, err)
+		if partialErr != nil {
+			event.Severity = notify.SeverityWarning
+			event.Error = partialErr.Error()
+		}
+		if err == nil {
+			event.SnapshotID = summary.SnapshotID
+			event.BytesAdded = int64(summary.DataAdded)
+			m.SetResticSummaryByBackupName(r.Name(), backup.Name, metricsSummary(summary))
+		}
+		publish(n, h, event)
+		ping.Finish(backup.Ping, err)
+		if err != nil {
+			m.AddResticErrorByBackupName(r.Name(), backup.Name)
+			m.AddJobError(string(notify.JobBackup), backup.Name, getErrorKind(backupCtx, err))
+			slog.Error("failed to create restic snapshot", "backup", backup.Name, "error", err)
+			return err
 		}
-		slog.Info("finished restic snapshot", "paths", backup.Path)
+
+		slog.Info("finished restic snapshot", "backup", backup.Name)
 		duration := time.Since(startedAt)
-		m.SetResticDurationByBackupName(backup.Name, duration.Seconds())
-	}
+		m.SetResticDurationByBackupName(r.Name(), backup.Name, duration.Seconds())
+		return nil
+	})...)
 
-	err := updateResticMetrics(c, m, r)
+	err = updateResticMetrics(ctx, c, m, repos)
 	if err != nil {
 		slog.Error("failed to update restic metrics", "error", err)
 	}
 
+	ping.Finish(c.Pings.Backup, finishHooks(errors.Join(errs...)))
 	slog.Info("restic backups completed")
 }
 
-func updateResticMetrics(c config.Config, m *metrics.Metrics, r restic.Restic) error {
-	snapshots, err := r.ListSnapshots()
+// backupDirectory runs the hooks of the backup once around the snapshot, only the snapshot is retried.
+// Containers come back and the repository lock is released while waiting for the next attempt.
+func backupDirectory(ctx context.Context, policy config.RetryConfig, m *metrics.Metrics, r restic.Restic, d *docker.Client, backup config.BackupConfig, report func(restic.BackupStatus)) (restic.BackupSummary, error) {
+	var summary restic.BackupSummary
+	err := runHooks(ctx, m, notify.JobBackup, backup.Name, "pre", backup.Hooks.Pre, hookEnv(backup, summary, hookStatusRunning, nil))
+	if err == nil {
+		err = retry(ctx, policy, m, notify.JobBackup, backup.Name, func() error {
+			var err error
+			summary, err = createSnapshot(ctx, r, d, backup, report)
+			return err
+		})
+	}
+
+	// A partial snapshot was still created, so it counts as success for the hooks
+	status := hookStatusSucceeded
+	if errors.Is(err, restic.ErrPartialBackup) {
+		status = hookStatusPartial
+	}
+	if err == nil || status == hookStatusPartial {
+		hookErr := runHooks(ctx, m, notify.JobBackup, backup.Name, "post_success", backup.Hooks.PostSuccess, hookEnv(backup, summary, status, err))
+		if hookErr != nil {
+			err = hookErr
+		}
+	}
+
+	if err != nil && !errors.Is(err, restic.ErrPartialBackup) {
+		status = hookStatusFailed
+		hookErr := runCleanupHooks(ctx, m, notify.JobBackup, backup.Name, "post_failure", backup.Hooks.PostFailure, hookEnv(backup, summary, status, err))
+		if hookErr != nil {
+			slog.Error("failed to run post failure hooks", "backup", backup.Name, "error", hookErr)
+		}
+	}
+
+	// Failing finally hooks are only logged, they do not change the result of the backup
+	hookErr := runCleanupHooks(ctx, m, notify.JobBackup, backup.Name, "finally", backup.Hooks.Finally, hookEnv(backup, summary, status, err))
+	if hookErr != nil {
+		slog.Error("failed to run finally hooks", "backup", backup.Name, "error", hookErr)
+	}
+
+	return summary, err
+}
+
+// createSnapshot suspends the containers of the backup and creates the restic snapshot of its source
+func createSnapshot(ctx context.Context, r restic.Restic, d *docker.Client, backup config.BackupConfig, report func(restic.BackupStatus)) (summary restic.BackupSummary, err error) {
+	// Wait for check and prune before the containers go down, the lock is released after they are back
+	release, err := r.SharedLock(ctx)
+	if err != nil {
+		return summary, err
+	}
+	defer release()
+
+	// Containers must come back, also if the backup fails or times out
+	resume, err := suspendContainers(ctx, d, backup.StopContainers, backup.PauseContainers)
+	defer func() {
+		if resumeErr := resume(); resumeErr != nil {
+			slog.Error("failed to resume containers after backup", "backup", backup.Name, "error", resumeErr)
+			// Containers that stay down fail the backup, also if the snapshot is only partial
+			if errors.Is(err, restic.ErrPartialBackup) {
+				err = resumeErr
+			} else {
+				err = errors.Join(err, resumeErr)
+			}
+		}
+	}()
+	if err != nil {
+		return summary, err
+	}
+
+	if backup.Database != nil {
+		dump, cleanup, err := database.NewDump(backup.Name, *backup.Database)
+		if err != nil {
+			return summary, fmt.Errorf("failed to prepare %s dump: %w", backup.Database.Type, err)
+		}
+		defer cleanup()
+		if backup.StdinFilename != "" {
+			dump.Filename = backup.StdinFilename
+		}
+
+		slog.Info("create restic snapshot from database dump", "backup", backup.Name, "type", backup.Database.Type, "filename", dump.Filename)
+		summary, err = r.BackupCommandOutput(ctx, backup.Name, dump.Filename, dump.Command, dump.Env, report)
+		if err != nil {
+			return summary, fmt.Errorf("failed to backup %s dump: %w", backup.Database.Type, err)
+		}
+	} else if backup.Command != "" {
+		slog.Info("create restic snapshot from command output", "backup", backup.Name, "filename", backup.StdinFilename)
+		summary, err = r.BackupCommandOutput(ctx, backup.Name, backup.StdinFilename, []string{"sh", "-c", backup.Command}, nil, report)
+		if err != nil {
+			return summary, fmt.Errorf("failed to backup output of command %s: %w", backup.Command, err)
+		}
+	} else {
+		slog.Info("create restic snapshot", "paths", backup.Paths)
+		summary, err = r.BackupDirectory(ctx, backup.Name, getBackupOptions(backup), report)
+		if errors.Is(err, restic.ErrPartialBackup) {
+			return summary, fmt.Errorf("partial backup of %s: %w", strings.Join(backup.Paths, ", "), err)
+		}
+		if err != nil {
+			return summary, fmt.Errorf("failed to backup %s: %w", strings.Join(backup.Paths, ", "), err)
+		}
+	}
+
+	return summary, nil
+}
+
+func metricsSummary(summary restic.BackupSummary) metrics.BackupSummary {
+	return metrics.BackupSummary{
+		SnapshotID:          summary.SnapshotID,
+		FilesNew:            summary.FilesNew,
+		FilesChanged:        summary.FilesChanged,
+		FilesUnmodified:     summary.FilesUnmodified,
+		DirsNew:             summary.DirsNew,
+		DirsChanged:         summary.DirsChanged,
+		DirsUnmodified:      summary.DirsUnmodified,
+		DataAdded:           summary.DataAdded,
+		DataAddedPacked:     summary.DataAddedPacked,
+		TotalBytesProcessed: summary.TotalBytesProcessed,
+	}
+}
+
+func getBackupOptions(backup config.BackupConfig) restic.BackupOptions {
+	return restic.BackupOptions{
+		Paths:             backup.Paths,
+		Excludes:          backup.Excludes,
+		IExcludes:         backup.IExcludes,
+		ExcludeFiles:      backup.ExcludeFiles,
+		ExcludeIfPresent:  backup.ExcludeIfPresent,
+		ExcludeLargerThan: backup.ExcludeLargerThan,
+		OneFileSystem:     backup.OneFileSystem,
+		FilesFrom:         backup.FilesFrom,
+	}
+}
+
+func updateResticMetrics(ctx context.Context, c config.Config, m *metrics.Metrics, repos restic.Repositories) error {
+	errs := []error{}
+	for _, r := range repos {
+		if err := updateRepositoryMetrics(ctx, c, m, r); err != nil {
+			errs = append(errs, fmt.Errorf("repository %s: %w", r.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func updateRepositoryMetrics(ctx context.Context, c config.Config, m *metrics.Metrics, r restic.Restic) error {
+	snapshots, err := r.ListSnapshots(ctx)
 	if err != nil {
 		m.AddSchedulerError(metrics.SchedulerErrorResticListSnapshots)
 		return fmt.Errorf("failed to list snapshots: %w", err)
@@ -104,6 +413,9 @@ func updateResticMetrics(c config.Config, m *metrics.Metrics, r restic.Restic) e
 	latestTime := map[string]float64{}
 
 	for _, backup := range c.Backups {
+		if backup.Repository != r.Name() {
+			continue
+		}
 		count[backup.Name] = 0
 		latestSize[backup.Name] = 0
 		totalSize[backup.Name] = 0
@@ -119,7 +431,7 @@ func updateResticMetrics(c config.Config, m *metrics.Metrics, r restic.Restic) e
 	}
 
 	for name := range snapshotBackupNames {
-		stats, err := r.GetSnapshotStatsByName(name)
+		stats, err := r.GetSnapshotStatsByName(ctx, name)
 		if err != nil {
 			m.AddSchedulerError(metrics.SchedulerErrorResticGetSnapshotStats)
 			return fmt.Errorf("failed to get snapshot stats: %w", err)
@@ -128,132 +440,246 @@ func updateResticMetrics(c config.Config, m *metrics.Metrics, r restic.Restic) e
 	}
 
 	for name := range count {
-		m.SetResticStatsByBackupName(name, count[name], totalSize[name], latestSize[name], float64(latestTime[name]))
+		m.SetResticStatsByBackupName(r.Name(), name, count[name], totalSize[name], latestSize[name], float64(latestTime[name]))
 	}
 
 	return nil
 }
 
-func createAndUploadEncryptedDump(r restic.Restic, s3 *s3.S3, snapshot restic.Snapshot, passphrase string) error {
-	// Create temporary directory to restore snapshot
-	tmpDir, err := os.MkdirTemp("", "restic-dump")
-	if err != nil {
-		return fmt.Errorf("failed to create temporary directory: %w", err)
-	}
-	defer os.RemoveAll(tmpDir)
+// streamUploader uploads the encrypted archives, implemented by *s3.S3
+type streamUploader interface {
+	StreamUploadFile(ctx context.Context, filename string, reader *io.PipeReader) error
+}
 
-	// Restore snapshot to temporary directory
-	slog.Info("restore snapshot to temporary directory", "snapshot", snapshot.Name)
-	if err := r.Restore(snapshot.ID, tmpDir); err != nil {
-		return fmt.Errorf("failed to restore snapshot: %w", err)
+// createAndUploadEncryptedDump calls report every second with the bytes of the tar archive and the bytes uploaded
+func createAndUploadEncryptedDump(ctx context.Context, r restic.Restic, s3 streamUploader, snapshot restic.Snapshot, passphrase string, mode config.S3DumpMode, report func(archived, uploaded int64)) (int64, error) {
+	var writeArchive func(w io.Writer) error
+
+	switch mode {
+	case config.S3DumpModeRestore:
+		// Create temporary directory to restore snapshot
+		tmpDir, err := os.MkdirTemp("", "restic-dump")
+		if err != nil {
+			return 0, fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		// Restore snapshot to temporary directory
+		slog.Info("restore snapshot to temporary directory", "snapshot", snapshot.Name)
+		if err := r.Restore(ctx, snapshot.ID, tmpDir); err != nil {
+			return 0, fmt.Errorf("failed to restore snapshot: %w", err)
+		}
+
+		writeArchive = func(w io.Writer) error {
+			tarWriter := tar.NewWriter(w)
+			err := utils.WriteDirectoryToTar(tarWriter, tmpDir)
+			if cerr := tarWriter.Close(); cerr != nil && err == nil {
+				err = fmt.Errorf("failed to close tar writer: %w", cerr)
+			}
+			return err
+		}
+	default:
+		// Let restic write the tar archive directly into the pipeline
+		writeArchive = func(w io.Writer) error {
+			return r.Dump(ctx, snapshot.ID, w)
+		}
 	}
 
 	// Stream tar.gz.age to S3
-	slog.Info("create encrypted archive and upload to s3", "snapshot", snapshot.Name)
+	slog.Info("create encrypted archive and upload to s3", "snapshot", snapshot.Name, "mode", mode)
 
 	// Create a pipe for streaming to S3
 	pr, pw := io.Pipe()
+	counter := &utils.CountingWriter{Writer: pw}
+	archived := &utils.CountingWriter{}
 	errCh := make(chan error, 1)
 
+	// Report progress until the upload is done
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
-		defer pw.Close()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				report(archived.Count(), counter.Count())
+			}
+		}
+	}()
+
+	go func() {
+		var err error
+		defer func() {
+			// The error is available before the upload sees it, so a failed archive is reported as the cause
+			errCh <- err
+			// Abort the upload if the archive could not be written completely
+			pw.CloseWithError(err)
+		}()
 
 		// Create age recipient
 		recipient, err := age.NewScryptRecipient(passphrase)
 		if err != nil {
-			errCh <- fmt.Errorf("failed to create age recipient: %w", err)
+			err = fmt.Errorf("failed to create age recipient: %w", err)
 			return
 		}
 
 		// Wrap pipe in age encryptor
-		ageWriter, err := age.Encrypt(pw, recipient)
+		ageWriter, err := age.Encrypt(counter, recipient)
 		if err != nil {
-			errCh <- fmt.Errorf("failed to create age encryptor: %w", err)
+			err = fmt.Errorf("failed to create age encryptor: %w", err)
 			return
 		}
 
 		// Wrap age in gzip
 		gzipWriter := pgzip.NewWriter(ageWriter)
 
-		// Wrap gzip in tar
-		tarWriter := tar.NewWriter(gzipWriter)
-		err = utils.WriteDirectoryToTar(tarWriter, tmpDir)
+		// Write tar archive into gzip
+		archived.Writer = gzipWriter
+		err = writeArchive(archived)
 
 		// Close all writers in correct order
-		if cerr := tarWriter.Close(); cerr != nil && err == nil {
-			err = fmt.Errorf("failed to close tar writer: %w", cerr)
-		}
-		if cerr := gzipWriter.Close(); cerr != nil && err == nil {
-			err = fmt.Errorf("failed to close gzip writer: %w", cerr)
+		if cerr := gzipWriter.Close(); cerr != nil {
+			if err == nil {
+				err = fmt.Errorf("failed to close gzip writer: %w", cerr)
+			}
+			// pgzip does not stop its output goroutine when closing fails, resetting does
+			gzipWriter.Reset(io.Discard)
 		}
 		if cerr := ageWriter.Close(); cerr != nil && err == nil {
 			err = fmt.Errorf("failed to close age writer: %w", cerr)
 		}
-
-		errCh <- err
 	}()
 
 	// Stream directly to S3
-	if err := s3.StreamUploadFile(snapshot.Name+".tar.gz.age", pr); err != nil {
-		return fmt.Errorf("failed to upload to s3: %w", err)
+	if err := s3.StreamUploadFile(ctx, snapshot.Name+".tar.gz.age", pr); err != nil {
+		// An archive that failed before the upload is the cause of the failed upload
+		select {
+		case archiveErr := <-errCh:
+			if archiveErr != nil {
+				return 0, fmt.Errorf("failed during archive creation: %w", archiveErr)
+			}
+			return 0, fmt.Errorf("failed to upload to s3: %w", err)
+		default:
+		}
+		// Unblock the archive writer, it fails as well now that the upload stopped reading
+		pr.CloseWithError(err)
+		<-errCh
+		return 0, fmt.Errorf("failed to upload to s3: %w", err)
 	}
 
 	// Wait for the goroutine to finish and catch errors
 	if err := <-errCh; err != nil {
-		return fmt.Errorf("failed during archive creation: %w", err)
+		return 0, fmt.Errorf("failed during archive creation: %w", err)
 	}
 
-	return nil
+	return counter.Count(), nil
 }
 
-func S3Backup(c config.Config, m *metrics.Metrics, r restic.Restic, s3 *s3.S3) {
+func S3Backup(ctx context.Context, c config.Config, m *metrics.Metrics, n *notify.Dispatcher, h *history.Store, p *progress.Tracker, repos restic.Repositories, s3 *s3.S3, backups []config.BackupConfig) {
 	slog.Info("creating s3 backups")
+	ping.Start(c.Pings.S3)
+
+	jobCtx, cancel := withTimeout(ctx, c.Timeouts.S3)
+	defer cancel()
 
-	snapshots, err := r.ListLatestSnapshots()
+	finishHooks, err := startJobHooks(jobCtx, c, m, notify.JobS3)
+	var snapshots []restic.Snapshot
+	if err == nil {
+		snapshots, err = repos.ListLatestSnapshots(jobCtx)
+		if err != nil {
+			m.AddSchedulerError(metrics.SchedulerErrorResticListSnapshots)
+			slog.Error("failed to list latest snapshots", "error", err)
+		}
+	}
 	if err != nil {
-		m.AddSchedulerError(metrics.SchedulerErrorResticListSnapshots)
-		slog.Error("failed to list latest snapshots", "error", err)
+		for _, backup := range backups {
+			publish(n, h, notify.NewFinishedEvent(notify.JobS3, backup.Name, time.Now(), err))
+			ping.Finish(backup.S3Ping, err)
+		}
+		ping.Finish(c.Pings.S3, finishHooks(err))
 		return
 	}
 
-	for _, backup := range c.Backups {
+	errs := forEachBackup(backups, c.Concurrency.Backups, func(backup config.BackupConfig) error {
 		startedAt := time.Now()
+		ping.Start(backup.S3Ping)
+		publish(n, h, notify.NewStartedEvent(notify.JobS3, backup.Name))
 
 		snapshot := restic.Snapshot{}
 		for _, s := range snapshots {
-			if s.Name == backup.Name {
+			if s.Name == backup.Name && s.Repository == backup.Repository {
 				snapshot = s
 				break
 			}
 		}
 
 		if snapshot.ID == "" {
+			err := fmt.Errorf("no snapshot found for backup %s", backup.Name)
 			m.AddS3ErrorByBackupName(backup.Name)
+			m.AddJobError(string(notify.JobS3), backup.Name, metrics.ErrorKindFailed)
 			slog.Warn("no snapshot found for backup", "backup", backup.Name)
-			continue
+			publish(n, h, notify.NewFinishedEvent(notify.JobS3, backup.Name, startedAt, err))
+			ping.Finish(backup.S3Ping, err)
+			return err
 		}
 
-		err = createAndUploadEncryptedDump(r, s3, snapshot, c.S3.Passphrase)
+		// The snapshot was listed from one of the repositories, so the lookup cannot fail
+		r, _ := repos.Get(snapshot.Repository)
+
+		backupCtx, cancel := withTimeout(jobCtx, backup.S3Timeout)
+		defer cancel()
+		var uploaded int64
+		p.Start(string(notify.JobS3), backup.Name)
+		err := retry(backupCtx, c.Retries.S3, m, notify.JobS3, backup.Name, func() error {
+			var err error
+			uploaded, err = createAndUploadEncryptedDump(backupCtx, r, s3, snapshot, c.S3.Passphrase, c.S3.DumpMode, func(archived, uploaded int64) {
+				// The archive is roughly as large as the snapshot, which is only known since restic 0.17
+				total := int64(snapshot.Summary.TotalBytesProcessed)
+				percent := 0.0
+				if total > 0 {
+					percent = min(float64(archived)/float64(total), 1)
+				}
+				p.Update(progress.Progress{
+					Job:         string(notify.JobS3),
+					Backup:      backup.Name,
+					PercentDone: percent,
+					BytesDone:   uploaded,
+				})
+			})
+			return err
+		})
+		p.Finish(string(notify.JobS3), backup.Name)
+		event := notify.NewFinishedEvent(notify.JobS3, backup.Name, startedAt, err)
+		event.SnapshotID = snapshot.ID
+		event.BytesUploaded = uploaded
+		publish(n, h, event)
+		ping.Finish(backup.S3Ping, err)
 
 		if err != nil {
 			m.AddS3ErrorByBackupName(backup.Name)
+			m.AddJobError(string(notify.JobS3), backup.Name, getErrorKind(backupCtx, err))
 			slog.Error("failed to create and upload snapshot to s3", "snapshot", snapshot.Name, "error", err)
-			continue
+			return err
 		}
 
 		m.SetS3DurationByBackupName(backup.Name, time.Since(startedAt).Seconds())
 		slog.Info("created and uploaded snapshot to s3", "snapshot", snapshot.Name)
-	}
+		return nil
+	})
 
-	err = updateS3Metrics(c, m, s3)
+	err = updateS3Metrics(ctx, c, m, s3)
 	if err != nil {
 		slog.Error("failed to update s3 metrics", "error", err)
 	}
 
+	ping.Finish(c.Pings.S3, finishHooks(errors.Join(errs...)))
 	slog.Info("s3 backups completed")
 }
 
-func updateS3Metrics(c config.Config, m *metrics.Metrics, s *s3.S3) error {
+func updateS3Metrics(ctx context.Context, c config.Config, m *metrics.Metrics, s *s3.S3) error {
 	count := map[string]int{}
 	totalSize := map[string]int64{}
 	latestSize := map[string]int64{}
@@ -266,7 +692,7 @@ func updateS3Metrics(c config.Config, m *metrics.Metrics, s *s3.S3) error {
 		latestTime[backup.Name] = 0
 	}
 
-	objects, err := s.ListObjects()
+	objects, err := s.ListObjects(ctx)
 	if err != nil {
 		m.AddSchedulerError(metrics.SchedulerErrorS3ListObjects)
 		return fmt.Errorf("failed to list s3 objects: %w", err)
@@ -295,16 +721,56 @@ func updateS3Metrics(c config.Config, m *metrics.Metrics, s *s3.S3) error {
 	return nil
 }
 
-func UpdateAllMetrics(c config.Config, m *metrics.Metrics, r restic.Restic, s *s3.S3) error {
-	err := updateResticMetrics(c, m, r)
+func UpdateAllMetrics(ctx context.Context, c config.Config, m *metrics.Metrics, repos restic.Repositories, s *s3.S3) error {
+	err := updateResticMetrics(ctx, c, m, repos)
 	if err != nil {
 		return fmt.Errorf("failed to update restic metrics: %w", err)
 	}
 
-	err = updateS3Metrics(c, m, s)
+	err = updateS3Metrics(ctx, c, m, s)
 	if err != nil {
 		return fmt.Errorf("failed to update s3 metrics: %w", err)
 	}
 
 	return nil
 }
+
+// publish records finished runs in the history and sends the event to the notifiers. The history is
+// written directly, since the notifiers drop events when their queue is full and catch-up needs every run.
+func publish(n *notify.Dispatcher, h *history.Store, e notify.Event) {
+	if err := h.Notify(context.Background(), e); err != nil {
+		slog.Error("failed to record run in history", "job", e.Job, "backup", e.Backup, "error", err)
+	}
+	n.Publish(e)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func getErrorKind(ctx context.Context, err error) metrics.ErrorKind {
+	err = utils.ContextError(ctx, err)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return metrics.ErrorKindTimeout
+	case errors.Is(err, context.Canceled):
+		return metrics.ErrorKindCancelled
+	case errors.Is(err, restic.ErrRepositoryLocked):
+		return metrics.ErrorKindLocked
+	case errors.Is(err, restic.ErrWrongPassword):
+		return metrics.ErrorKindWrongPassword
+	case errors.Is(err, restic.ErrRepoNotFound):
+		return metrics.ErrorKindRepoNotFound
+	case errors.Is(err, restic.ErrSourceUnreadable):
+		return metrics.ErrorKindSourceUnreadable
+	case errors.Is(err, restic.ErrPartialBackup):
+		return metrics.ErrorKindPartial
+	case errors.Is(err, restic.ErrSourceCommand):
+		return metrics.ErrorKindCommandFailed
+	default:
+		return metrics.ErrorKindFailed
+	}
+}
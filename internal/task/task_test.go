@@ -0,0 +1,85 @@
+package task
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/korbiniankuhn/auto-restic/internal/config"
+	"github.com/korbiniankuhn/auto-restic/internal/docker"
+	"github.com/korbiniankuhn/auto-restic/internal/metrics"
+	"github.com/korbiniankuhn/auto-restic/internal/restic"
+)
+
+// Runs the source command of a backup like restic does for --stdin-from-command
+const resticStub = `#!/bin/sh
+[ "$1" = "backup" ] || exit 0
+while [ $# -gt 0 ] && [ "$1" != "--" ]; do shift; done
+shift
+"$@" > /dev/null || { echo "Fatal: unable to save snapshot: command failed: exit status 1" >&2; exit 1; }
+echo '{"message_type":"summary","snapshot_id":"abc"}'
+`
+
+const pgDumpStub = `#!/bin/sh
+env > "$DUMP_ENV_FILE"
+exit 1
+`
+
+func writeStub(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o755); err != nil {
+		t.Fatalf("failed to write %s stub: %v", name, err)
+	}
+}
+
+func TestCreateSnapshotFailingDump(t *testing.T) {
+	dir := t.TempDir()
+	writeStub(t, dir, "restic", resticStub)
+	writeStub(t, dir, "pg_dump", pgDumpStub)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	envFile := filepath.Join(dir, "env")
+	t.Setenv("DUMP_ENV_FILE", envFile)
+	t.Setenv("DB_PASSWORD", "database-secret")
+
+	ctx := context.Background()
+	r, err := restic.NewRestic(ctx, "default", restic.Options{Repository: filepath.Join(dir, "repo"), Password: "restic-secret"})
+	if err != nil {
+		t.Fatalf("failed to create restic: %v", err)
+	}
+
+	backup := config.BackupConfig{
+		Name: "app-db",
+		Database: &config.DatabaseConfig{
+			Type:        config.DatabaseTypePostgres,
+			Host:        "db",
+			Port:        5432,
+			User:        "postgres",
+			PasswordEnv: "DB_PASSWORD",
+			Database:    "app",
+		},
+	}
+
+	_, err = createSnapshot(ctx, r, docker.New(""), backup, nil)
+	if err == nil {
+		t.Fatal("expected an error for a failing pg_dump")
+	}
+	if kind := getErrorKind(ctx, err); kind != metrics.ErrorKindCommandFailed {
+		t.Errorf("error kind = %s, want %s", kind, metrics.ErrorKindCommandFailed)
+	}
+
+	// pg_dump gets the database password, but not the credentials of the repository
+	env, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatalf("pg_dump did not run: %v", err)
+	}
+	if !strings.Contains(string(env), "PGPASSWORD=database-secret") {
+		t.Error("pg_dump environment is missing PGPASSWORD")
+	}
+	for _, key := range []string{"RESTIC_PASSWORD=", "RESTIC_REPOSITORY="} {
+		if strings.Contains(string(env), key) {
+			t.Errorf("pg_dump environment contains %s", key)
+		}
+	}
+}
@@ -2,10 +2,13 @@ package utils
 
 import (
 	"archive/tar"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"syscall"
 )
 
@@ -152,3 +155,28 @@ func writeFileToTar(tarWriter *tar.Writer, filePath string) error {
 	_, err = io.Copy(tarWriter, file)
 	return err
 }
+
+// CountingWriter counts the bytes written to the underlying writer
+type CountingWriter struct {
+	Writer io.Writer
+	count  atomic.Int64
+}
+
+func (w *CountingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.count.Add(int64(n))
+	return n, err
+}
+
+func (w *CountingWriter) Count() int64 {
+	return w.count.Load()
+}
+
+// ContextError marks errors of cancelled or timed out work with the context error,
+// errors that already wrap it are returned as they are
+func ContextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		return fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	return err
+}